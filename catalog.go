@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+//go:embed catalog/*.csv
+var catalogFS embed.FS
+
+type catalogBrandSeed struct {
+	Brand Brand
+	File  string
+}
+
+var catalogSeeds = []catalogBrandSeed{
+	{Brand: Brand{Code: "dmc", Name: "DMC", SkeinLength: 8, Strands: 6}, File: "catalog/dmc.csv"},
+	{Brand: Brand{Code: "anchor", Name: "Anchor", SkeinLength: 8, Strands: 6}, File: "catalog/anchor.csv"},
+}
+
+const catalogConversionsFile = "catalog/conversions.csv"
+
+// NormalizeBrandCode ramène "DMC ", "dmc" ou "D.M.C." au même code.
+func NormalizeBrandCode(brand string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(brand) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// NormalizeColorNumber ramène " blanc" ou "b5200" à la forme du catalogue.
+func NormalizeColorNumber(number string) string {
+	return strings.ToUpper(strings.TrimSpace(number))
+}
+
+// ParseHexColor accepte "#RRGGBB" ou "RRGGBB".
+func ParseHexColor(hex string) (r, g, b uint8, err error) {
+	hex = strings.TrimPrefix(strings.TrimSpace(hex), "#")
+	if len(hex) != 6 {
+		return 0, 0, 0, fmt.Errorf("invalid hex colour %q", hex)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid hex colour %q", hex)
+	}
+	return uint8(v >> 16), uint8(v >> 8), uint8(v), nil
+}
+
+func readCatalogColors(file string) ([]CatalogColor, error) {
+	f, err := catalogFS.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	reader := csv.NewReader(f)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	if strings.Join(header, ",") != "number,name,hex,discontinued" {
+		return nil, fmt.Errorf("%s: unexpected header %v", file, header)
+	}
+
+	var colors []CatalogColor
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+
+		r, g, b, err := ParseHexColor(record[2])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		discontinued, _ := strconv.ParseBool(record[3])
+		colors = append(colors, CatalogColor{
+			Number:       NormalizeColorNumber(record[0]),
+			Name:         record[1],
+			Hex:          fmt.Sprintf("#%02X%02X%02X", r, g, b),
+			R:            r,
+			G:            g,
+			B:            b,
+			Discontinued: discontinued,
+		})
+	}
+	return colors, nil
+}
+
+// SeedCatalog charge le jeu de données embarqué. Idempotent : les lignes
+// existantes sont mises à jour.
+func SeedCatalog(ctx context.Context, repo CatalogRepository, tx Transactor) error {
+	return tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		for _, seed := range catalogSeeds {
+			brand := seed.Brand
+			if err := repo.UpsertBrand(ctx, &brand); err != nil {
+				return err
+			}
+			// ON CONFLICT ne renvoie pas toujours l'id, on relit la marque
+			stored, err := repo.GetBrandByCode(ctx, brand.Code)
+			if err != nil {
+				return err
+			}
+
+			colors, err := readCatalogColors(seed.File)
+			if err != nil {
+				return err
+			}
+			for i := range colors {
+				colors[i].BrandID = stored.ID
+			}
+			if err := repo.UpsertColors(ctx, colors); err != nil {
+				return err
+			}
+		}
+		return seedConversions(ctx, repo)
+	})
+}
+
+func seedConversions(ctx context.Context, repo CatalogRepository) error {
+	f, err := catalogFS.Open(catalogConversionsFile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	reader := csv.NewReader(f)
+	if _, err := reader.Read(); err != nil {
+		return err
+	}
+
+	// Cache des coloris par marque pour éviter une requête par ligne
+	colorsByBrand := map[string]map[string]uint{}
+	lookup := func(brandCode, number string) (uint, error) {
+		colors, ok := colorsByBrand[brandCode]
+		if !ok {
+			brand, err := repo.GetBrandByCode(ctx, brandCode)
+			if err != nil {
+				return 0, fmt.Errorf("%s: unknown brand %q", catalogConversionsFile, brandCode)
+			}
+			list, err := repo.GetColors(ctx, brand.ID, "", true)
+			if err != nil {
+				return 0, err
+			}
+			colors = make(map[string]uint, len(list))
+			for _, c := range list {
+				colors[c.Number] = c.ID
+			}
+			colorsByBrand[brandCode] = colors
+		}
+		id, ok := colors[NormalizeColorNumber(number)]
+		if !ok {
+			return 0, fmt.Errorf("%s: unknown colour %s %s", catalogConversionsFile, brandCode, number)
+		}
+		return id, nil
+	}
+
+	var conversions []ColorConversion
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", catalogConversionsFile, err)
+		}
+
+		fromID, err := lookup(record[0], record[1])
+		if err != nil {
+			return err
+		}
+		toID, err := lookup(record[2], record[3])
+		if err != nil {
+			return err
+		}
+		conversions = append(conversions,
+			ColorConversion{FromColorID: fromID, ToColorID: toID},
+			ColorConversion{FromColorID: toID, ToColorID: fromID},
+		)
+	}
+	return repo.UpsertConversions(ctx, conversions)
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/text/unicode/norm"
+)
+
+var ErrInvalidRender = errors.New("invalid render options")
+
+const (
+	ChartFormatSVG = "svg"
+	ChartFormatPNG = "png"
+	ChartFormatPDF = "pdf"
+
+	ChartModeSymbol = "symbol"
+	ChartModeColor  = "color"
+)
+
+// Les pages sont mises en page en points, au format A4 portrait.
+const (
+	chartPageWidth  = 595.0
+	chartPageHeight = 842.0
+	chartMargin     = 36.0
+	chartHeader     = 24.0
+	chartCellSize   = 10.0
+	// Nombre de points brodés par page de grille
+	chartTileWidth  = 50
+	chartTileHeight = 70
+	// Lignes de légende par page
+	chartKeyRows      = 34
+	chartKeyRowHeight = 20.0
+	// Pixels par point pour le rendu PNG
+	chartPNGScale = 2
+)
+
+// chartSymbols évite les caractères qui se confondent une fois imprimés
+// (0/O, 1/I/l, c/C...). Au-delà, un chiffre est ajouté au symbole.
+const chartSymbols = "XO+#*@%&=/<>^~?!$SZTVWHKMNABCDEFGJLPQRUY23456789abdefghkmnpqrstuvwyz"
+
+func chartSymbol(i int) string {
+	s := string(chartSymbols[i%len(chartSymbols)])
+	if i >= len(chartSymbols) {
+		s += strconv.Itoa(i / len(chartSymbols))
+	}
+	return s
+}
+
+var (
+	chartBlack     = color.RGBA{0, 0, 0, 255}
+	chartWhite     = color.RGBA{255, 255, 255, 255}
+	chartGridLight = color.RGBA{170, 170, 170, 255}
+	chartGridDark  = color.RGBA{40, 40, 40, 255}
+)
+
+// chartInk renvoie la couleur d'un coloris, gris si son code est illisible.
+func chartInk(c ChartColor) color.RGBA {
+	r, g, b, err := ParseHexColor(c.Hex)
+	if err != nil {
+		return color.RGBA{128, 128, 128, 255}
+	}
+	return color.RGBA{r, g, b, 255}
+}
+
+// chartContrast choisit un symbole noir ou blanc lisible sur le fond c.
+func chartContrast(c color.RGBA) color.RGBA {
+	if RGBToLab(c.R, c.G, c.B).L < 55 {
+		return chartWhite
+	}
+	return chartBlack
+}
+
+// chartCanvas est une surface de dessin en points, origine en haut à gauche.
+// Les lignes tracées sont toujours horizontales ou verticales.
+type chartCanvas interface {
+	Rect(x, y, w, h float64, fill color.RGBA)
+	Line(x1, y1, x2, y2, width float64, stroke color.RGBA)
+	// Text écrit s à partir de x, sur la ligne de base y.
+	Text(x, y, size float64, s string, fill color.RGBA)
+	// Symbol centre s sur (cx, cy) dans une police à chasse fixe.
+	Symbol(cx, cy, size float64, s string, fill color.RGBA)
+}
+
+// chartLayout découpe une grille en pages de chartTileWidth x chartTileHeight
+// points, suivies des pages de légende.
+type chartLayout struct {
+	chart    *Chart
+	key      []ChartKeyEntry
+	mode     string
+	cols     int
+	rows     int
+	keyPages int
+}
+
+func newChartLayout(chart *Chart, key []ChartKeyEntry, mode string) *chartLayout {
+	return &chartLayout{
+		chart:    chart,
+		key:      key,
+		mode:     mode,
+		cols:     max(1, (chart.Width+chartTileWidth-1)/chartTileWidth),
+		rows:     max(1, (chart.Height+chartTileHeight-1)/chartTileHeight),
+		keyPages: max(1, (len(key)+chartKeyRows-1)/chartKeyRows),
+	}
+}
+
+func (l *chartLayout) Pages() int {
+	return l.cols*l.rows + l.keyPages
+}
+
+// Draw dessine la page page (à partir de 0).
+func (l *chartLayout) Draw(c chartCanvas, page int) {
+	c.Rect(0, 0, chartPageWidth, chartPageHeight, chartWhite)
+	if page < l.cols*l.rows {
+		l.drawGrid(c, page)
+		return
+	}
+	l.drawKey(c, page-l.cols*l.rows)
+}
+
+func (l *chartLayout) drawGrid(c chartCanvas, page int) {
+	grid := l.chart.Grid()
+	x0 := (page % l.cols) * chartTileWidth
+	y0 := (page / l.cols) * chartTileHeight
+	x1 := min(x0+chartTileWidth, grid.Width)
+	y1 := min(y0+chartTileHeight, grid.Height)
+
+	title := fmt.Sprintf("%s — page %d/%d — colonnes %d-%d, lignes %d-%d",
+		l.chart.Title, page+1, l.Pages(), x0+1, x1, y0+1, y1)
+	c.Text(chartMargin, chartMargin, 11, title, chartBlack)
+
+	ox := chartMargin
+	oy := chartMargin + chartHeader
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			idx := grid.At(x, y)
+			if idx < 0 || idx >= len(l.key) {
+				continue
+			}
+			cx := ox + float64(x-x0)*chartCellSize
+			cy := oy + float64(y-y0)*chartCellSize
+			ink := chartBlack
+			if l.mode == ChartModeColor {
+				fill := chartInk(l.key[idx].Color)
+				c.Rect(cx, cy, chartCellSize, chartCellSize, fill)
+				ink = chartContrast(fill)
+			}
+			c.Symbol(cx+chartCellSize/2, cy+chartCellSize/2, 7, l.key[idx].Symbol, ink)
+		}
+	}
+
+	// Quadrillage, renforcé toutes les 10 cases en coordonnées absolues pour
+	// que les pages se raccordent
+	right := ox + float64(x1-x0)*chartCellSize
+	bottom := oy + float64(y1-y0)*chartCellSize
+	for x := x0; x <= x1; x++ {
+		px := ox + float64(x-x0)*chartCellSize
+		if x%10 == 0 || x == x1 {
+			c.Line(px, oy, px, bottom, 1, chartGridDark)
+			if x%10 == 0 {
+				c.Text(px-3, oy-3, 6, strconv.Itoa(x), chartBlack)
+			}
+		} else {
+			c.Line(px, oy, px, bottom, 0.25, chartGridLight)
+		}
+	}
+	for y := y0; y <= y1; y++ {
+		py := oy + float64(y-y0)*chartCellSize
+		if y%10 == 0 || y == y1 {
+			c.Line(ox, py, right, py, 1, chartGridDark)
+			if y%10 == 0 {
+				c.Text(ox-18, py+2, 6, strconv.Itoa(y), chartBlack)
+			}
+		} else {
+			c.Line(ox, py, right, py, 0.25, chartGridLight)
+		}
+	}
+}
+
+func (l *chartLayout) drawKey(c chartCanvas, page int) {
+	title := fmt.Sprintf("%s — légende %d/%d", l.chart.Title, page+1, l.keyPages)
+	c.Text(chartMargin, chartMargin, 11, title, chartBlack)
+
+	columns := []float64{chartMargin, chartMargin + 30, chartMargin + 95, chartMargin + 160, chartMargin + 390, chartMargin + 460}
+	y := chartMargin + chartHeader
+	for i, label := range []string{"", "Marque", "Numéro", "Nom", "Points", "En stock"} {
+		c.Text(columns[i], y, 9, label, chartBlack)
+	}
+	c.Line(chartMargin, y+5, chartPageWidth-chartMargin, y+5, 0.5, chartGridDark)
+
+	start := page * chartKeyRows
+	end := min(start+chartKeyRows, len(l.key))
+	for i := start; i < end; i++ {
+		entry := l.key[i]
+		top := y + 10 + float64(i-start)*chartKeyRowHeight
+		baseline := top + chartKeyRowHeight/2 + 3
+
+		size := chartKeyRowHeight - 6
+		sx, sy := columns[0], top+3
+		ink := chartBlack
+		if l.mode == ChartModeColor {
+			fill := chartInk(entry.Color)
+			c.Rect(sx, sy, size, size, fill)
+			ink = chartContrast(fill)
+		}
+		c.Line(sx, sy, sx+size, sy, 0.5, chartGridDark)
+		c.Line(sx, sy+size, sx+size, sy+size, 0.5, chartGridDark)
+		c.Line(sx, sy, sx, sy+size, 0.5, chartGridDark)
+		c.Line(sx+size, sy, sx+size, sy+size, 0.5, chartGridDark)
+		c.Symbol(sx+size/2, sy+size/2, 9, entry.Symbol, ink)
+
+		name := []rune(entry.Color.Name)
+		if len(name) > 40 {
+			name = append(name[:39], '…')
+		}
+		c.Text(columns[1], baseline, 9, entry.Color.Brand, chartBlack)
+		c.Text(columns[2], baseline, 9, entry.Color.ThreadId, chartBlack)
+		c.Text(columns[3], baseline, 9, string(name), chartBlack)
+		c.Text(columns[4], baseline, 9, strconv.Itoa(entry.Stitches), chartBlack)
+		c.Text(columns[5], baseline, 9, strconv.FormatInt(entry.Owned, 10), chartBlack)
+	}
+}
+
+// --- SVG ---
+
+type svgCanvas struct {
+	buf bytes.Buffer
+}
+
+func svgColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func (s *svgCanvas) Rect(x, y, w, h float64, fill color.RGBA) {
+	fmt.Fprintf(&s.buf, `<rect x="%.2f" y="%.2f" width="%.2f" height="%.2f" fill="%s"/>`+"\n", x, y, w, h, svgColor(fill))
+}
+
+func (s *svgCanvas) Line(x1, y1, x2, y2, width float64, stroke color.RGBA) {
+	fmt.Fprintf(&s.buf, `<line x1="%.2f" y1="%.2f" x2="%.2f" y2="%.2f" stroke="%s" stroke-width="%.2f"/>`+"\n", x1, y1, x2, y2, svgColor(stroke), width)
+}
+
+func (s *svgCanvas) Text(x, y, size float64, text string, fill color.RGBA) {
+	fmt.Fprintf(&s.buf, `<text x="%.2f" y="%.2f" font-family="Helvetica, Arial, sans-serif" font-size="%.1f" fill="%s">%s</text>`+"\n",
+		x, y, size, svgColor(fill), html.EscapeString(text))
+}
+
+func (s *svgCanvas) Symbol(cx, cy, size float64, text string, fill color.RGBA) {
+	fmt.Fprintf(&s.buf, `<text x="%.2f" y="%.2f" font-family="Courier, monospace" font-weight="bold" font-size="%.1f" text-anchor="middle" fill="%s">%s</text>`+"\n",
+		cx, cy+0.35*size, size, svgColor(fill), html.EscapeString(text))
+}
+
+func writeChartSVG(w io.Writer, layout *chartLayout, page int) error {
+	canvas := &svgCanvas{}
+	layout.Draw(canvas, page)
+	_, err := fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" width="%.0fpt" height="%.0fpt" viewBox="0 0 %.0f %.0f">
+%s</svg>
+`, chartPageWidth, chartPageHeight, chartPageWidth, chartPageHeight, canvas.buf.String())
+	return err
+}
+
+// --- PNG ---
+
+// pngCanvas rastérise la page ; le texte utilise une police bitmap de taille
+// fixe, la taille demandée est ignorée.
+type pngCanvas struct {
+	img *image.RGBA
+}
+
+func (p *pngCanvas) scale(v float64) int {
+	return int(math.Round(v * chartPNGScale))
+}
+
+func (p *pngCanvas) Rect(x, y, w, h float64, fill color.RGBA) {
+	r := image.Rect(p.scale(x), p.scale(y), p.scale(x+w), p.scale(y+h))
+	draw.Draw(p.img, r, image.NewUniform(fill), image.Point{}, draw.Src)
+}
+
+func (p *pngCanvas) Line(x1, y1, x2, y2, width float64, stroke color.RGBA) {
+	t := max(1, p.scale(width))
+	r := image.Rect(p.scale(x1), p.scale(y1), p.scale(x2), p.scale(y2)).Canon()
+	if r.Dx() < r.Dy() {
+		r.Min.X -= t / 2
+		r.Max.X = r.Min.X + t
+	} else {
+		r.Min.Y -= t / 2
+		r.Max.Y = r.Min.Y + t
+	}
+	draw.Draw(p.img, r, image.NewUniform(stroke), image.Point{}, draw.Src)
+}
+
+func (p *pngCanvas) drawer(fill color.RGBA) *font.Drawer {
+	return &font.Drawer{Dst: p.img, Src: image.NewUniform(fill), Face: basicfont.Face7x13}
+}
+
+func (p *pngCanvas) Text(x, y, size float64, text string, fill color.RGBA) {
+	d := p.drawer(fill)
+	d.Dot = fixed.P(p.scale(x), p.scale(y))
+	d.DrawString(asciiFold(text))
+}
+
+// asciiFold ramène le texte aux caractères de la police bitmap : accents
+// retirés, tirets et points de suspension remplacés.
+func asciiFold(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		switch {
+		case r < unicode.MaxASCII:
+			b.WriteRune(r)
+		case unicode.Is(unicode.Mn, r):
+		case r == '—' || r == '–':
+			b.WriteByte('-')
+		case r == '…':
+			b.WriteString("...")
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+func (p *pngCanvas) Symbol(cx, cy, size float64, text string, fill color.RGBA) {
+	d := p.drawer(fill)
+	width := d.MeasureString(text).Round()
+	// Face7x13 : 11 pixels au-dessus de la ligne de base, 2 en dessous
+	d.Dot = fixed.P(p.scale(cx)-width/2, p.scale(cy)+4)
+	d.DrawString(text)
+}
+
+func writeChartPNG(w io.Writer, layout *chartLayout, page int) error {
+	canvas := &pngCanvas{img: image.NewRGBA(image.Rect(0, 0, chartPageWidth*chartPNGScale, chartPageHeight*chartPNGScale))}
+	layout.Draw(canvas, page)
+	return png.Encode(w, canvas.img)
+}
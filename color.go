@@ -0,0 +1,132 @@
+package main
+
+import "math"
+
+// Lab est une couleur dans l'espace CIELAB (illuminant D65, observateur 2°).
+type Lab struct {
+	L float64
+	A float64
+	B float64
+}
+
+func srgbToLinear(c uint8) float64 {
+	v := float64(c) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// RGBToLab convertit une couleur sRGB 8 bits en CIELAB.
+func RGBToLab(r, g, b uint8) Lab {
+	lr, lg, lb := srgbToLinear(r), srgbToLinear(g), srgbToLinear(b)
+
+	x := lr*0.4124564 + lg*0.3575761 + lb*0.1804375
+	y := lr*0.2126729 + lg*0.7151522 + lb*0.0721750
+	z := lr*0.0193339 + lg*0.1191920 + lb*0.9503041
+
+	// Blanc de référence D65
+	fx := labF(x / 0.95047)
+	fy := labF(y / 1.00000)
+	fz := labF(z / 1.08883)
+
+	return Lab{
+		L: 116*fy - 16,
+		A: 500 * (fx - fy),
+		B: 200 * (fy - fz),
+	}
+}
+
+func labF(t float64) float64 {
+	const delta = 6.0 / 29.0
+	if t > delta*delta*delta {
+		return math.Cbrt(t)
+	}
+	return t/(3*delta*delta) + 4.0/29.0
+}
+
+// CIEDE2000 renvoie la différence perceptuelle ΔE00 entre deux couleurs.
+// Une valeur inférieure à ~2 est difficilement perceptible à l'œil nu.
+func CIEDE2000(c1, c2 Lab) float64 {
+	const kL, kC, kH = 1.0, 1.0, 1.0
+
+	cab1 := math.Hypot(c1.A, c1.B)
+	cab2 := math.Hypot(c2.A, c2.B)
+	cabMean := (cab1 + cab2) / 2
+	cabMean7 := math.Pow(cabMean, 7)
+	g := 0.5 * (1 - math.Sqrt(cabMean7/(cabMean7+math.Pow(25, 7))))
+
+	a1 := (1 + g) * c1.A
+	a2 := (1 + g) * c2.A
+	cp1 := math.Hypot(a1, c1.B)
+	cp2 := math.Hypot(a2, c2.B)
+	hp1 := hueAngle(c1.B, a1)
+	hp2 := hueAngle(c2.B, a2)
+
+	dL := c2.L - c1.L
+	dC := cp2 - cp1
+
+	var dh float64
+	switch {
+	case cp1*cp2 == 0:
+		dh = 0
+	case math.Abs(hp2-hp1) <= 180:
+		dh = hp2 - hp1
+	case hp2-hp1 > 180:
+		dh = hp2 - hp1 - 360
+	default:
+		dh = hp2 - hp1 + 360
+	}
+	dH := 2 * math.Sqrt(cp1*cp2) * math.Sin(radians(dh/2))
+
+	lMean := (c1.L + c2.L) / 2
+	cpMean := (cp1 + cp2) / 2
+
+	var hMean float64
+	switch {
+	case cp1*cp2 == 0:
+		hMean = hp1 + hp2
+	case math.Abs(hp1-hp2) <= 180:
+		hMean = (hp1 + hp2) / 2
+	case hp1+hp2 < 360:
+		hMean = (hp1 + hp2 + 360) / 2
+	default:
+		hMean = (hp1 + hp2 - 360) / 2
+	}
+
+	t := 1 -
+		0.17*math.Cos(radians(hMean-30)) +
+		0.24*math.Cos(radians(2*hMean)) +
+		0.32*math.Cos(radians(3*hMean+6)) -
+		0.20*math.Cos(radians(4*hMean-63))
+
+	dTheta := 30 * math.Exp(-math.Pow((hMean-275)/25, 2))
+	cpMean7 := math.Pow(cpMean, 7)
+	rc := 2 * math.Sqrt(cpMean7/(cpMean7+math.Pow(25, 7)))
+	lMean50 := (lMean - 50) * (lMean - 50)
+	sl := 1 + 0.015*lMean50/math.Sqrt(20+lMean50)
+	sc := 1 + 0.045*cpMean
+	sh := 1 + 0.015*cpMean*t
+	rt := -math.Sin(radians(2*dTheta)) * rc
+
+	tl := dL / (kL * sl)
+	tc := dC / (kC * sc)
+	th := dH / (kH * sh)
+
+	return math.Sqrt(tl*tl + tc*tc + th*th + rt*tc*th)
+}
+
+func hueAngle(b, a float64) float64 {
+	if a == 0 && b == 0 {
+		return 0
+	}
+	h := math.Atan2(b, a) * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+	return h
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
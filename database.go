@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"os"
 
 	"gorm.io/driver/postgres"
@@ -22,3 +24,307 @@ func NewConnection() (*gorm.DB, error) {
 	}
 	return db, nil
 }
+
+type txKey struct{}
+
+// txState porte la transaction en cours et les actions à exécuter après son commit.
+type txState struct {
+	tx          *gorm.DB
+	afterCommit []func()
+}
+
+// Transactor exécute plusieurs appels de repositories dans une même transaction.
+// La transaction est portée par le contexte passé à fn.
+type Transactor interface {
+	WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type gormTransactor struct {
+	db *gorm.DB
+}
+
+func NewTransactor(db *gorm.DB) Transactor {
+	return &gormTransactor{db: db}
+}
+
+func (t *gormTransactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	db := t.db.WithContext(ctx)
+	// Une transaction est déjà ouverte : GORM pose un savepoint, ce qui permet
+	// d'annuler un appel imbriqué sans perdre la transaction englobante
+	parent, nested := ctx.Value(txKey{}).(*txState)
+	if nested {
+		db = parent.tx.WithContext(ctx)
+	}
+
+	state := &txState{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		state.tx = tx
+		return fn(context.WithValue(ctx, txKey{}, state))
+	})
+	if err != nil {
+		return err
+	}
+
+	// Les actions d'un savepoint attendent le commit de la transaction englobante
+	if nested {
+		parent.afterCommit = append(parent.afterCommit, state.afterCommit...)
+		return nil
+	}
+	for _, f := range state.afterCommit {
+		f()
+	}
+	return nil
+}
+
+// AfterCommit exécute fn après le commit de la transaction en cours, ou
+// immédiatement s'il n'y en a pas. fn n'est jamais appelée en cas de rollback.
+func AfterCommit(ctx context.Context, fn func()) {
+	if state, ok := ctx.Value(txKey{}).(*txState); ok {
+		state.afterCommit = append(state.afterCommit, fn)
+		return
+	}
+	fn()
+}
+
+// dbFromContext renvoie la transaction en cours s'il y en a une, sinon db.
+func dbFromContext(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if state, ok := ctx.Value(txKey{}).(*txState); ok {
+		return state.tx.WithContext(ctx)
+	}
+	return db.WithContext(ctx)
+}
+
+type threadIdentityConflict struct {
+	UserID   uint
+	Brand    string
+	ThreadId string
+	Count    int64
+}
+
+// MigrateThreadIdentity fait passer l'identité d'un fil de (user, thread_id)
+// à (user, brand, thread_id). À exécuter avant AutoMigrate.
+func MigrateThreadIdentity(db *gorm.DB, log *slog.Logger) error {
+	m := db.Migrator()
+	if !m.HasTable(&Thread{}) || !m.HasIndex(&Thread{}, "idx_user_thread") {
+		return nil
+	}
+
+	// Les lignes supprimées (soft delete) comptent aussi : l'index unique les couvre
+	var conflicts []threadIdentityConflict
+	err := db.Raw(`SELECT user_id, TRIM(brand) AS brand, thread_id, COUNT(*) AS count
+		FROM threads
+		GROUP BY user_id, TRIM(brand), thread_id
+		HAVING COUNT(*) > 1`).Scan(&conflicts).Error
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		for _, c := range conflicts {
+			log.Error("Thread identity conflict", "user_id", c.UserID, "brand", c.Brand, "thread_id", c.ThreadId, "count", c.Count)
+		}
+		return fmt.Errorf("%d thread identity conflicts must be resolved before migrating", len(conflicts))
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("UPDATE threads SET brand = TRIM(brand) WHERE brand <> TRIM(brand)").Error; err != nil {
+			return err
+		}
+		if err := tx.Migrator().DropIndex(&Thread{}, "idx_user_thread"); err != nil {
+			return err
+		}
+		log.Info("Thread identity migrated to (user, brand, thread_id)")
+		return nil
+	})
+}
+
+// MigrateEmailVerification ajoute User.EmailVerifiedAt et considère vérifiées
+// les adresses des comptes existants, créés avant la vérification. À exécuter
+// avant AutoMigrate.
+func MigrateEmailVerification(db *gorm.DB, log *slog.Logger) error {
+	m := db.Migrator()
+	if !m.HasTable(&User{}) || m.HasColumn(&User{}, "EmailVerifiedAt") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Migrator().AddColumn(&User{}, "EmailVerifiedAt"); err != nil {
+			return err
+		}
+		result := tx.Exec("UPDATE users SET email_verified_at = created_at")
+		if result.Error != nil {
+			return result.Error
+		}
+		log.Info("Existing accounts marked as verified", "count", result.RowsAffected)
+		return nil
+	})
+}
+
+// MigrateThreadFormats remplace les drapeaux is_e/is_c/is_s par les quantités
+// par format : le stock va au premier format coché (échevette, carte puis
+// bobine), en échevettes si aucun ne l'est. À exécuter après AutoMigrate.
+func MigrateThreadFormats(db *gorm.DB, log *slog.Logger) error {
+	m := db.Migrator()
+	if !m.HasColumn(&Thread{}, "is_e") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		// Plusieurs drapeaux cochés : on ne peut pas répartir, on le signale
+		var ambiguous int64
+		err := tx.Raw(`SELECT COUNT(*) FROM threads
+			WHERE COALESCE(is_e, false)::int + COALESCE(is_c, false)::int + COALESCE(is_s, false)::int > 1
+			AND thread_count > 0`).Scan(&ambiguous).Error
+		if err != nil {
+			return err
+		}
+		if ambiguous > 0 {
+			log.Warn("Threads with several format flags migrated to their first format", "count", ambiguous)
+		}
+
+		err = tx.Exec(`UPDATE threads SET
+			skeins = CASE WHEN COALESCE(is_e, false) OR NOT (COALESCE(is_c, false) OR COALESCE(is_s, false)) THEN thread_count ELSE 0 END,
+			cards = CASE WHEN NOT COALESCE(is_e, false) AND COALESCE(is_c, false) THEN thread_count ELSE 0 END,
+			spools = CASE WHEN NOT COALESCE(is_e, false) AND NOT COALESCE(is_c, false) AND COALESCE(is_s, false) THEN thread_count ELSE 0 END`).Error
+		if err != nil {
+			return err
+		}
+		for _, column := range []string{"is_e", "is_c", "is_s"} {
+			if err := tx.Migrator().DropColumn(&Thread{}, column); err != nil {
+				return err
+			}
+		}
+		log.Info("Thread format flags migrated to per-format quantities")
+		return nil
+	})
+}
+
+// BackfillShoppingList ajoute à la liste de courses, à sa création, les fils
+// déjà à zéro : ensuite, seules les écritures de fils la tiennent à jour. À
+// exécuter après MigrateThreadFormats. L'index unique partiel idx_shopping_open
+// (un article non acheté par coloris) écarte les doublons.
+func BackfillShoppingList(db *gorm.DB, log *slog.Logger) error {
+	result := db.Exec(`INSERT INTO shopping_list_items (created_at, updated_at, user_id, brand, thread_id, quantity, source, note)
+		SELECT NOW(), NOW(), user_id, brand, thread_id, GREATEST(COALESCE(min_quantity, 1), 1), ?, ''
+		FROM threads
+		WHERE deleted_at IS NULL AND thread_count = 0
+		ON CONFLICT (user_id, brand, thread_id) WHERE bought_at IS NULL DO NOTHING`, ShoppingSourceAuto)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Info("Shopping list backfilled with empty threads", "count", result.RowsAffected)
+	}
+	return nil
+}
+
+type threadCatalogRow struct {
+	ID       uint
+	UserID   uint
+	Brand    string
+	ThreadId string
+}
+
+// MigrateThreadCatalog relie au catalogue les fils enregistrés avant lui : la
+// marque et le numéro prennent la forme du catalogue, catalog_color_id est
+// renseigné et les coloris introuvables sont marqués personnalisés. Les fils
+// qui prendraient l'identité d'un autre sont signalés et bloquent la
+// migration. À exécuter après SeedCatalog.
+func MigrateThreadCatalog(ctx context.Context, db *gorm.DB, catalog *CatalogService, log *slog.Logger) error {
+	// Un fil résolu est soit relié au catalogue, soit personnalisé
+	var legacy []threadCatalogRow
+	err := db.WithContext(ctx).Unscoped().Model(&Thread{}).
+		Where("catalog_color_id IS NULL AND NOT COALESCE(is_custom, false)").
+		Find(&legacy).Error
+	if err != nil || len(legacy) == 0 {
+		return err
+	}
+
+	resolved := make(map[uint]Thread, len(legacy))
+	users := map[uint]bool{}
+	for _, row := range legacy {
+		thread := Thread{Brand: row.Brand, ThreadId: row.ThreadId}
+		if err := catalog.ResolveThread(ctx, &thread); err != nil {
+			return err
+		}
+		resolved[row.ID] = thread
+		users[row.UserID] = true
+	}
+
+	// Les lignes supprimées (soft delete) comptent aussi : l'index unique les couvre
+	userIDs := make([]uint, 0, len(users))
+	for id := range users {
+		userIDs = append(userIDs, id)
+	}
+	var existing []threadCatalogRow
+	err = db.WithContext(ctx).Unscoped().Model(&Thread{}).
+		Where("user_id IN ?", userIDs).
+		Find(&existing).Error
+	if err != nil {
+		return err
+	}
+	type identity struct {
+		userID   uint
+		brand    string
+		threadID string
+	}
+	identities := map[identity][]uint{}
+	for _, row := range existing {
+		key := identity{userID: row.UserID, brand: row.Brand, threadID: row.ThreadId}
+		if thread, ok := resolved[row.ID]; ok {
+			key.brand, key.threadID = thread.Brand, thread.ThreadId
+		}
+		identities[key] = append(identities[key], row.ID)
+	}
+	conflicts := 0
+	for key, ids := range identities {
+		if len(ids) > 1 {
+			conflicts++
+			log.Error("Thread catalogue conflict", "user_id", key.userID, "brand", key.brand, "thread_id", key.threadID, "ids", ids)
+		}
+	}
+	if conflicts > 0 {
+		return fmt.Errorf("%d thread catalogue conflicts must be resolved before migrating", conflicts)
+	}
+
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		linked, renamed := 0, 0
+		for _, row := range legacy {
+			thread := resolved[row.ID]
+			err := tx.Unscoped().Model(&Thread{}).Where("id = ?", row.ID).Updates(map[string]any{
+				"brand":            thread.Brand,
+				"thread_id":        thread.ThreadId,
+				"is_custom":        thread.IsCustom,
+				"catalog_color_id": thread.CatalogColorID,
+			}).Error
+			if err != nil {
+				return err
+			}
+			if thread.CatalogColorID != nil {
+				linked++
+			}
+			if thread.Brand == row.Brand && thread.ThreadId == row.ThreadId {
+				continue
+			}
+			renamed++
+
+			// Les articles ajoutés par le fil le suivent ; un article ouvert
+			// existe déjà sous la nouvelle identité si on l'a ajouté à la main
+			err = tx.Exec(`DELETE FROM shopping_list_items o
+				WHERE o.user_id = ? AND o.brand = ? AND o.thread_id = ? AND o.bought_at IS NULL
+				AND EXISTS (SELECT 1 FROM shopping_list_items n
+					WHERE n.user_id = o.user_id AND n.brand = ? AND n.thread_id = ? AND n.bought_at IS NULL)`,
+				row.UserID, row.Brand, row.ThreadId, thread.Brand, thread.ThreadId).Error
+			if err != nil {
+				return err
+			}
+			err = tx.Model(&ShoppingListItem{}).
+				Where("user_id = ? AND brand = ? AND thread_id = ?", row.UserID, row.Brand, row.ThreadId).
+				Updates(map[string]any{"brand": thread.Brand, "thread_id": thread.ThreadId}).Error
+			if err != nil {
+				return err
+			}
+		}
+		log.Info("Threads linked to the colour catalogue", "count", len(legacy), "linked", linked, "custom", len(legacy)-linked, "renamed", renamed)
+		return nil
+	})
+}
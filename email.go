@@ -3,9 +3,11 @@ package main
 import (
 	"crypto/tls"
 	"fmt"
+	"html"
 	"log/slog"
 	"net/smtp"
 	"os"
+	"strings"
 )
 
 type EmailService struct {
@@ -125,6 +127,81 @@ func (s *EmailService) SendPasswordResetEmail(to string, token string) error {
 	return s.SendEmail(to, subject, body)
 }
 
+func (s *EmailService) SendVerificationEmail(to string, token string) error {
+	verifyLink := fmt.Sprintf("%s/verify-email?token=%s", os.Getenv("FRONTEND_URL"), token)
+	subject := "Verify your email address"
+	body := fmt.Sprintf(`
+		<html>
+		<body style="font-family: Arial, sans-serif; background-color: #f4f4f4; padding: 20px;">
+			<div style="max-width: 600px; margin: 0 auto; background-color: #ffffff; padding: 30px; border-radius: 10px; box-shadow: 0 4px 6px rgba(0,0,0,0.1);">
+				<h2 style="color: #4f46e5; text-align: center;">Email Verification</h2>
+				<p>Hello,</p>
+				<p>Thank you for creating a <strong>threadStocks</strong> account.</p>
+				<p>Click the button below to confirm your email address. This link will expire in 24 hours.</p>
+				<div style="text-align: center; margin: 30px 0;">
+					<a href="%s" style="background-color: #4f46e5; color: white; padding: 12px 24px; text-decoration: none; border-radius: 5px; font-weight: bold;">Verify my email</a>
+				</div>
+				<p>If you did not create this account, you can safely ignore this email.</p>
+				<hr style="border: 0; border-top: 1px solid #eeeeee; margin: 20px 0;">
+				<p style="font-size: 12px; color: #888888; text-align: center;">&copy; 2026 threadStocks. All rights reserved.</p>
+			</div>
+		</body>
+		</html>
+	`, verifyLink)
+
+	return s.SendEmail(to, subject, body)
+}
+
+func (s *EmailService) SendEmailChangeConfirmation(to string, token string) error {
+	confirmLink := fmt.Sprintf("%s/confirm-email-change?token=%s", os.Getenv("FRONTEND_URL"), token)
+	subject := "Confirm your new email address"
+	body := fmt.Sprintf(`
+		<html>
+		<body style="font-family: Arial, sans-serif; background-color: #f4f4f4; padding: 20px;">
+			<div style="max-width: 600px; margin: 0 auto; background-color: #ffffff; padding: 30px; border-radius: 10px; box-shadow: 0 4px 6px rgba(0,0,0,0.1);">
+				<h2 style="color: #4f46e5; text-align: center;">Email Address Change</h2>
+				<p>Hello,</p>
+				<p>You have requested to use this address for your <strong>threadStocks</strong> account.</p>
+				<p>Click the button below to confirm the change. This link will expire in 24 hours.</p>
+				<div style="text-align: center; margin: 30px 0;">
+					<a href="%s" style="background-color: #4f46e5; color: white; padding: 12px 24px; text-decoration: none; border-radius: 5px; font-weight: bold;">Confirm my new email</a>
+				</div>
+				<p>If you did not request this change, you can safely ignore this email.</p>
+				<hr style="border: 0; border-top: 1px solid #eeeeee; margin: 20px 0;">
+				<p style="font-size: 12px; color: #888888; text-align: center;">&copy; 2026 threadStocks. All rights reserved.</p>
+			</div>
+		</body>
+		</html>
+	`, confirmLink)
+
+	return s.SendEmail(to, subject, body)
+}
+
+func (s *EmailService) SendEmailChangeNotice(to string, newEmail string, token string) error {
+	cancelLink := fmt.Sprintf("%s/cancel-email-change?token=%s", os.Getenv("FRONTEND_URL"), token)
+	subject := "Your email address is being changed"
+	body := fmt.Sprintf(`
+		<html>
+		<body style="font-family: Arial, sans-serif; background-color: #f4f4f4; padding: 20px;">
+			<div style="max-width: 600px; margin: 0 auto; background-color: #ffffff; padding: 30px; border-radius: 10px; box-shadow: 0 4px 6px rgba(0,0,0,0.1);">
+				<h2 style="color: #4f46e5; text-align: center;">Email Address Change</h2>
+				<p>Hello,</p>
+				<p>A request was made to change the email address of your <strong>threadStocks</strong> account to <strong>%s</strong>.</p>
+				<p>The change will only apply once it is confirmed from the new address.</p>
+				<p>If you did not request this change, cancel it and change your password.</p>
+				<div style="text-align: center; margin: 30px 0;">
+					<a href="%s" style="background-color: #dc2626; color: white; padding: 12px 24px; text-decoration: none; border-radius: 5px; font-weight: bold;">Cancel the change</a>
+				</div>
+				<hr style="border: 0; border-top: 1px solid #eeeeee; margin: 20px 0;">
+				<p style="font-size: 12px; color: #888888; text-align: center;">&copy; 2026 threadStocks. All rights reserved.</p>
+			</div>
+		</body>
+		</html>
+	`, html.EscapeString(newEmail), cancelLink)
+
+	return s.SendEmail(to, subject, body)
+}
+
 func (s *EmailService) SendContactEmail(name, email, subject, message string) error {
 	to := os.Getenv("CONTACT_EMAIL")
 	emailSubject := fmt.Sprintf("New contact message: %s", subject)
@@ -149,3 +226,35 @@ func (s *EmailService) SendContactEmail(name, email, subject, message string) er
 
 	return s.SendEmail(to, emailSubject, body)
 }
+
+func (s *EmailService) SendLowStockEmail(to string, threads []Thread) error {
+	subject := "Some of your threads are running low"
+	var rows strings.Builder
+	for _, t := range threads {
+		rows.WriteString(fmt.Sprintf(`<tr><td style="padding: 6px 12px;">%s</td><td style="padding: 6px 12px;">%s</td><td style="padding: 6px 12px; text-align: right;">%d</td></tr>`,
+			html.EscapeString(t.Brand), html.EscapeString(t.ThreadId), t.ThreadCount))
+	}
+	body := fmt.Sprintf(`
+		<html>
+		<body style="font-family: Arial, sans-serif; background-color: #f4f4f4; padding: 20px;">
+			<div style="max-width: 600px; margin: 0 auto; background-color: #ffffff; padding: 30px; border-radius: 10px; box-shadow: 0 4px 6px rgba(0,0,0,0.1);">
+				<h2 style="color: #4f46e5; text-align: center;">Low Stock Alert</h2>
+				<p>Hello,</p>
+				<p>The following threads in your <strong>threadStocks</strong> inventory have dropped below their minimum quantity:</p>
+				<table style="width: 100%%; border-collapse: collapse; margin: 20px 0;">
+					<tr style="background-color: #f9fafb;"><th style="padding: 6px 12px; text-align: left;">Brand</th><th style="padding: 6px 12px; text-align: left;">Number</th><th style="padding: 6px 12px; text-align: right;">In stock</th></tr>
+					%s
+				</table>
+				<div style="text-align: center; margin: 30px 0;">
+					<a href="%s/threads" style="background-color: #4f46e5; color: white; padding: 12px 24px; text-decoration: none; border-radius: 5px; font-weight: bold;">View my low stock</a>
+				</div>
+				<p>You will not be notified again for these threads until they are restocked.</p>
+				<hr style="border: 0; border-top: 1px solid #eeeeee; margin: 20px 0;">
+				<p style="font-size: 12px; color: #888888; text-align: center;">&copy; 2026 threadStocks. All rights reserved.</p>
+			</div>
+		</body>
+		</html>
+	`, rows.String(), os.Getenv("FRONTEND_URL"))
+
+	return s.SendEmail(to, subject, body)
+}
@@ -2,21 +2,28 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"path/filepath"
 	"strconv"
+	"strings"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/codes"
+	"gorm.io/gorm"
 )
 
 // --- Account Handler ---
 
 type AccountHandler struct {
-	service *AccountService
+	service  *AccountService
+	sessions *SessionService
+	proxies  TrustedProxies
 }
 
-func NewAccountHandler(service *AccountService) *AccountHandler {
-	return &AccountHandler{service: service}
+func NewAccountHandler(service *AccountService, sessions *SessionService, proxies TrustedProxies) *AccountHandler {
+	return &AccountHandler{service: service, sessions: sessions, proxies: proxies}
 }
 
 func (h *AccountHandler) Me(w http.ResponseWriter, r *http.Request) {
@@ -55,7 +62,7 @@ func (h *AccountHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := h.service.Login(ctx, req.Email, req.Password)
+	tokens, err := h.service.Login(ctx, req.Email, req.Password, h.sessionInfo(r))
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
@@ -67,10 +74,10 @@ func (h *AccountHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.setTokenCookie(w, token)
-	w.WriteHeader(http.StatusOK)
+	h.setTokenCookies(w, tokens)
 	w.Header().Set("Content-Type", "application/json")
-	if _, err := w.Write([]byte("{}")); err != nil {
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(tokens); err != nil {
 		span.RecordError(err)
 	}
 }
@@ -85,7 +92,7 @@ func (h *AccountHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := h.service.Register(ctx, req)
+	tokens, err := h.service.Register(ctx, req, h.sessionInfo(r))
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
@@ -97,10 +104,10 @@ func (h *AccountHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.setTokenCookie(w, token)
-	w.WriteHeader(http.StatusCreated)
+	h.setTokenCookies(w, tokens)
 	w.Header().Set("Content-Type", "application/json")
-	if _, err := w.Write([]byte("{}")); err != nil {
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(tokens); err != nil {
 		span.RecordError(err)
 	}
 }
@@ -153,6 +160,144 @@ func (h *AccountHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(map[string]string{"message": "Password updated successfully"})
 }
 
+func (h *AccountHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("account-handler").Start(r.Context(), "VerifyEmail")
+	defer span.End()
+
+	var req VerifyEmailDto
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.VerifyEmail(ctx, req.Token); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		w.Header().Set("Content-Type", "application/json")
+		if errors.Is(err, ErrInvalidVerificationToken) {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Failed to verify email"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": "Email verified successfully"})
+}
+
+func (h *AccountHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("account-handler").Start(r.Context(), "ResendVerification")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	if err := h.service.ResendVerification(ctx, userID); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case errors.Is(err, ErrEmailAlreadyVerified):
+			w.WriteHeader(http.StatusConflict)
+		case errors.Is(err, ErrVerificationThrottled):
+			w.Header().Set("Retry-After", strconv.Itoa(int(verificationResendInterval.Seconds())))
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "Failed to send verification email"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": "Verification email sent"})
+}
+
+// ChangeEmail demande le changement d'adresse, appliqué une fois confirmé
+// depuis la nouvelle adresse.
+func (h *AccountHandler) ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("account-handler").Start(r.Context(), "ChangeEmail")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	var req ChangeEmailDto
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.RequestEmailChange(ctx, userID, req); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeEmailChangeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": "Confirmation email sent to the new address"})
+}
+
+func (h *AccountHandler) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("account-handler").Start(r.Context(), "ConfirmEmailChange")
+	defer span.End()
+
+	var req EmailChangeTokenDto
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.ConfirmEmailChange(ctx, req.Token); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeEmailChangeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": "Email address updated successfully"})
+}
+
+func (h *AccountHandler) CancelEmailChange(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("account-handler").Start(r.Context(), "CancelEmailChange")
+	defer span.End()
+
+	var req EmailChangeTokenDto
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.CancelEmailChange(ctx, req.Token); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeEmailChangeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": "Email change cancelled"})
+}
+
+func writeEmailChangeError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case errors.Is(err, ErrInvalidEmailChange), errors.Is(err, ErrInvalidCurrentPassword):
+		w.WriteHeader(http.StatusBadRequest)
+	case errors.Is(err, ErrEmailTaken):
+		w.WriteHeader(http.StatusConflict)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Failed to change email address"})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
 func (h *AccountHandler) Contact(w http.ResponseWriter, r *http.Request) {
 	ctx, span := otel.Tracer("account-handler").Start(r.Context(), "Contact")
 	defer span.End()
@@ -178,19 +323,80 @@ func (h *AccountHandler) Contact(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *AccountHandler) Logout(w http.ResponseWriter, r *http.Request) {
-	_, span := otel.Tracer("account-handler").Start(r.Context(), "Logout")
+	ctx, span := otel.Tracer("account-handler").Start(r.Context(), "Logout")
 	defer span.End()
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "token",
-		Value:    "",
-		MaxAge:   -1,
-		Path:     "/",
-		HttpOnly: true,
-	})
+	access, _ := getTokenFromRequest(r)
+	var refresh string
+	if cookie, err := r.Cookie("refresh_token"); err == nil {
+		refresh = cookie.Value
+	}
+	if err := h.sessions.Logout(ctx, access, refresh); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	for _, name := range []string{"token", "refresh_token"} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			MaxAge:   -1,
+			Path:     "/",
+			HttpOnly: true,
+		})
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
+// Refresh échange un jeton de rafraîchissement, lu dans le corps ou dans le
+// cookie refresh_token, contre un nouveau couple de jetons.
+func (h *AccountHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("account-handler").Start(r.Context(), "Refresh")
+	defer span.End()
+
+	var req RefreshTokenDto
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	if req.RefreshToken == "" {
+		if cookie, err := r.Cookie("refresh_token"); err == nil {
+			req.RefreshToken = cookie.Value
+		}
+	}
+	if req.RefreshToken == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "refresh token is required"})
+		return
+	}
+
+	tokens, err := h.sessions.Refresh(ctx, req.RefreshToken)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		w.Header().Set("Content-Type", "application/json")
+		if errors.Is(err, ErrInvalidSession) {
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Failed to refresh token"})
+		return
+	}
+
+	h.setTokenCookies(w, tokens)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tokens); err != nil {
+		span.RecordError(err)
+	}
+}
+
 func (h *AccountHandler) UpdatePassword(w http.ResponseWriter, r *http.Request) {
 	ctx, span := otel.Tracer("account-handler").Start(r.Context(), "UpdatePassword")
 	defer span.End()
@@ -221,60 +427,105 @@ func (h *AccountHandler) UpdatePassword(w http.ResponseWriter, r *http.Request)
 	w.WriteHeader(http.StatusOK)
 }
 
-func (h *AccountHandler) setTokenCookie(w http.ResponseWriter, token string) {
-	http.SetCookie(w, &http.Cookie{
-		Name:     "token",
-		Value:    token,
-		MaxAge:   86400,
-		Path:     "/",
-		Secure:   false,
-		HttpOnly: true,
-		SameSite: http.SameSiteLaxMode,
-	})
-}
-
-func (h *AccountHandler) Logout(w http.ResponseWriter, r *http.Request) {
-	_, span := otel.Tracer("account-handler").Start(r.Context(), "Logout")
+func (h *AccountHandler) Sessions(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("account-handler").Start(r.Context(), "Sessions")
 	defer span.End()
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "token",
-		Value:    "",
-		MaxAge:   -1,
-		Path:     "/",
-		HttpOnly: true,
-	})
-	w.WriteHeader(http.StatusOK)
+	userID, _ := GetUserIDFromContext(ctx)
+	sessionID, _ := GetSessionIDFromContext(ctx)
+	sessions, err := h.sessions.GetSessions(ctx, userID, sessionID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(sessions); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
 }
 
-func (h *AccountHandler) UpdatePassword(w http.ResponseWriter, r *http.Request) {
-	ctx, span := otel.Tracer("account-handler").Start(r.Context(), "UpdatePassword")
+func (h *AccountHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("account-handler").Start(r.Context(), "RevokeSession")
 	defer span.End()
 
-	userId, _ := GetUserIDFromContext(ctx)
-	var dto PasswordDto
-	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+	userID, _ := GetUserIDFromContext(ctx)
+	idStr := r.PathValue("id")
+	id64, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := h.sessions.Revoke(ctx, userID, uint(id64)); err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
-		w.WriteHeader(http.StatusBadRequest)
+		writeServiceError(w, err)
 		return
 	}
 
-	user, err := h.service.repo.GetByID(ctx, userId)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeOtherSessions déconnecte tous les autres appareils et garde la session courante.
+func (h *AccountHandler) RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("account-handler").Start(r.Context(), "RevokeOtherSessions")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	sessionID, _ := GetSessionIDFromContext(ctx)
+	revoked, err := h.sessions.RevokeOthers(ctx, userID, sessionID)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
 
-	errUPassword := h.service.UpdatePassword(ctx, dto, user)
-	if errUPassword != nil {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int64{"revoked": revoked})
+}
+
+// JWKS publie les clés publiques de vérification des jetons, pour les
+// services qui authentifient les utilisateurs sans le secret de signature.
+func (h *AccountHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	_, span := otel.Tracer("account-handler").Start(r.Context(), "JWKS")
+	defer span.End()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := json.NewEncoder(w).Encode(h.sessions.JWKS()); err != nil {
 		span.RecordError(err)
-		span.SetStatus(codes.Error, errUPassword.Error())
-		w.WriteHeader(http.StatusBadRequest)
+		span.SetStatus(codes.Error, err.Error())
 	}
+}
 
-	w.WriteHeader(http.StatusOK)
+func (h *AccountHandler) sessionInfo(r *http.Request) SessionInfo {
+	return SessionInfo{UserAgent: r.UserAgent(), IP: h.proxies.ClientIP(r)}
+}
+
+func (h *AccountHandler) setTokenCookies(w http.ResponseWriter, tokens *TokenPair) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "token",
+		Value:    tokens.AccessToken,
+		MaxAge:   int(tokens.ExpiresIn),
+		Path:     "/",
+		Secure:   false,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    tokens.RefreshToken,
+		MaxAge:   int(sessionLifetime.Seconds()),
+		Path:     "/",
+		Secure:   false,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
 }
 
 // --- Thread Handler ---
@@ -292,19 +543,135 @@ func (h *ThreadHandler) GetAll(w http.ResponseWriter, r *http.Request) {
 	defer span.End()
 
 	userID, _ := GetUserIDFromContext(ctx)
-	threads, err := h.service.GetThreadsByUserID(ctx, userID)
+	query, err := parseThreadListQuery(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	page, err := h.service.ListThreads(ctx, userID, query)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeServiceError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(threads); err != nil {
+	if err := json.NewEncoder(w).Encode(page); err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
 	}
 }
 
+// validationErrors sont les erreurs de validation des services, renvoyées au
+// client avec un code 400.
+var validationErrors = []error{
+	ErrInvalidMovementReason, ErrInvalidQuantity, ErrInvalidThreadQuery,
+	ErrThreadWithoutColor, ErrInvalidLocation, ErrInvalidTag,
+	ErrInvalidOXS, ErrInvalidImage, ErrInvalidRender, ErrInvalidCalculation,
+}
+
+// writeServiceError traduit les erreurs des services en code HTTP : 404 pour
+// une ressource absente, 400 pour une erreur de validation, 500 sinon.
+func writeServiceError(w http.ResponseWriter, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	for _, target := range validationErrors {
+		if errors.Is(err, target) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+func parseThreadListQuery(r *http.Request) (ThreadListQuery, error) {
+	values := r.URL.Query()
+	query := ThreadListQuery{
+		Sort:  values.Get("sort"),
+		Brand: values.Get("brand"),
+	}
+
+	// "sort=-brand" est un raccourci pour "sort=brand&order=desc"
+	if strings.HasPrefix(query.Sort, "-") {
+		query.Sort = strings.TrimPrefix(query.Sort, "-")
+		query.Desc = true
+	}
+	switch values.Get("order") {
+	case "", "asc":
+	case "desc":
+		query.Desc = true
+	default:
+		return query, errors.New("invalid order")
+	}
+
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return query, errors.New("invalid limit")
+		}
+		query.Limit = limit
+	}
+
+	if v := values.Get("cursor"); v != "" {
+		cursor, err := DecodeThreadCursor(v)
+		if err != nil {
+			return query, err
+		}
+		query.Cursor = cursor
+	}
+
+	for key, dest := range map[string]**bool{"is_e": &query.IsE, "is_c": &query.IsC, "is_s": &query.IsS} {
+		if v := values.Get(key); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return query, fmt.Errorf("invalid %s", key)
+			}
+			*dest = &b
+		}
+	}
+
+	for key, dest := range map[string]**int64{"min_count": &query.MinCount, "max_count": &query.MaxCount} {
+		if v := values.Get(key); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return query, fmt.Errorf("invalid %s", key)
+			}
+			*dest = &n
+		}
+	}
+
+	// "tag=a&tag=b" ne garde que les fils portant les deux étiquettes
+	for _, tag := range values["tag"] {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			query.Tags = append(query.Tags, tag)
+		}
+	}
+
+	// "location=none" liste les fils sans emplacement
+	switch v := values.Get("location"); v {
+	case "":
+	case "none":
+		query.Unlocated = true
+	default:
+		id, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return query, errors.New("invalid location")
+		}
+		locationID := uint(id)
+		query.LocationID = &locationID
+	}
+
+	return query, nil
+}
+
 func (h *ThreadHandler) Create(w http.ResponseWriter, r *http.Request) {
 	ctx, span := otel.Tracer("thread-handler").Start(r.Context(), "Create")
 	defer span.End()
@@ -316,20 +683,12 @@ func (h *ThreadHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	thread := Thread{
-		UserID:      userID,
-		ThreadId:    dto.ThreadId,
-		IsE:         dto.IsE,
-		IsC:         dto.IsC,
-		IsS:         dto.IsS,
-		Brand:       dto.Brand,
-		ThreadCount: dto.ThreadCount,
-	}
+	thread := NewThreadFromDto(userID, dto)
 
-	if err := h.service.CreateThread(ctx, &thread); err != nil {
+	if err := h.service.CreateThread(ctx, &thread, r.URL.Query().Get("reason")); err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		writeServiceError(w, err)
 		return
 	}
 
@@ -346,16 +705,16 @@ func (h *ThreadHandler) DeleteMultiple(w http.ResponseWriter, r *http.Request) {
 	defer span.End()
 
 	userID, _ := GetUserIDFromContext(ctx)
-	var ids []string
-	if err := json.NewDecoder(r.Body).Decode(&ids); err != nil {
+	var keys []ThreadKey
+	if err := json.NewDecoder(r.Body).Decode(&keys); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	if err := h.service.DeleteMultiple(ctx, userID, ids); err != nil {
+	if err := h.service.DeleteMultiple(ctx, userID, keys, r.URL.Query().Get("reason")); err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		writeServiceError(w, err)
 		return
 	}
 
@@ -381,21 +740,13 @@ func (h *ThreadHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	thread := Thread{
-		UserID:      userID,
-		ThreadId:    dto.ThreadId,
-		IsE:         dto.IsE,
-		IsC:         dto.IsC,
-		IsS:         dto.IsS,
-		Brand:       dto.Brand,
-		ThreadCount: dto.ThreadCount,
-	}
+	thread := NewThreadFromDto(userID, dto)
 	thread.ID = id
 
-	if err := h.service.UpdateThread(ctx, &thread); err != nil {
+	if err := h.service.UpdateThread(ctx, &thread, r.URL.Query().Get("reason")); err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		writeServiceError(w, err)
 		return
 	}
 
@@ -407,8 +758,8 @@ func (h *ThreadHandler) Update(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (h *ThreadHandler) Delete(w http.ResponseWriter, r *http.Request) {
-	ctx, span := otel.Tracer("thread-handler").Start(r.Context(), "Delete")
+func (h *ThreadHandler) Patch(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("thread-handler").Start(r.Context(), "Patch")
 	defer span.End()
 
 	userID, _ := GetUserIDFromContext(ctx)
@@ -420,12 +771,1473 @@ func (h *ThreadHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	}
 	id := uint(id64)
 
-	if err := h.service.DeleteThread(ctx, userID, id); err != nil {
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "" && !strings.HasPrefix(contentType, "application/merge-patch+json") && !strings.HasPrefix(contentType, "application/json") {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	thread, err := h.service.PatchThread(ctx, userID, id, patch, r.URL.Query().Get("reason"))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(thread); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func (h *ThreadHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("thread-handler").Start(r.Context(), "Delete")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	idStr := r.PathValue("id")
+	id64, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	id := uint(id64)
+
+	if err := h.service.DeleteThread(ctx, userID, id, r.URL.Query().Get("reason")); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *ThreadHandler) Movements(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("thread-handler").Start(r.Context(), "Movements")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	idStr := r.PathValue("id")
+	id64, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	id := uint(id64)
+
+	history, err := h.service.GetMovements(ctx, userID, id)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(history); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func parseSimilarQuery(r *http.Request) (bool, int, error) {
+	values := r.URL.Query()
+	inStockOnly := false
+	if v := values.Get("in_stock"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, 0, errors.New("invalid in_stock")
+		}
+		inStockOnly = b
+	}
+	limit := 0
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return false, 0, errors.New("invalid limit")
+		}
+		limit = n
+	}
+	return inStockOnly, limit, nil
+}
+
+func (h *ThreadHandler) SimilarToHex(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("thread-handler").Start(r.Context(), "SimilarToHex")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	inStockOnly, limit, err := parseSimilarQuery(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	similar, err := h.service.FindSimilarToHex(ctx, userID, r.URL.Query().Get("hex"), inStockOnly, limit)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(similar); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func (h *ThreadHandler) SimilarToThread(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("thread-handler").Start(r.Context(), "SimilarToThread")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	idStr := r.PathValue("id")
+	id64, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	id := uint(id64)
+
+	inStockOnly, limit, err := parseSimilarQuery(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	similar, err := h.service.FindSimilarToThread(ctx, userID, id, inStockOnly, limit)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(similar); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func (h *ThreadHandler) Batch(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("thread-handler").Start(r.Context(), "Batch")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	var req BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.service.Batch(ctx, userID, req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		if errors.Is(err, ErrBatchTooLarge) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !result.Committed {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func (h *ThreadHandler) LowStock(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("thread-handler").Start(r.Context(), "LowStock")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	threads, err := h.service.GetLowStock(ctx, userID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(threads); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func (h *ThreadHandler) SetLowStockDefault(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("thread-handler").Start(r.Context(), "SetLowStockDefault")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	var dto LowStockDefaultDto
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.SetDefaultMinQuantity(ctx, userID, dto.DefaultMinQuantity); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *ThreadHandler) Trash(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("thread-handler").Start(r.Context(), "Trash")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	threads, err := h.service.GetTrash(ctx, userID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(threads); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func (h *ThreadHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("thread-handler").Start(r.Context(), "Restore")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	idStr := r.PathValue("id")
+	id64, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	id := uint(id64)
+
+	thread, err := h.service.RestoreThread(ctx, userID, id, r.URL.Query().Get("reason"))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(thread); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func (h *ThreadHandler) Purge(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("thread-handler").Start(r.Context(), "Purge")
+	defer span.End()
+
+	if r.PathValue("action") != "purge" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	userID, _ := GetUserIDFromContext(ctx)
+	idStr := r.PathValue("id")
+	id64, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	id := uint(id64)
+
+	if err := h.service.PurgeThread(ctx, userID, id); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+const maxImportSize = 10 << 20
+
+// Import attend un formulaire multipart : "file" (CSV) et "mapping" (JSON
+// optionnel champ -> colonne). ?dry_run=true valide sans rien enregistrer.
+func (h *ThreadHandler) Import(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("thread-handler").Start(r.Context(), "Import")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "file is required"})
+		return
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	var mapping ThreadCSVMapping
+	if v := r.FormValue("mapping"); v != "" {
+		if err := json.Unmarshal([]byte(v), &mapping); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid mapping"})
+			return
+		}
+	}
+
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	report, err := h.service.ImportCSV(ctx, userID, file, mapping, dryRun, r.URL.Query().Get("reason"))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func (h *ThreadHandler) Export(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("thread-handler").Start(r.Context(), "Export")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	if format := r.URL.Query().Get("format"); format != "" && format != "csv" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "unsupported format"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="threads.csv"`)
+	if err := h.service.ExportCSV(ctx, userID, w); err != nil {
+		// Les en-têtes sont peut-être déjà partis, on ne peut que tracer
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// --- Project Handler ---
+
+type ProjectHandler struct {
+	service *ProjectService
+}
+
+func NewProjectHandler(service *ProjectService) *ProjectHandler {
+	return &ProjectHandler{service: service}
+}
+
+func (h *ProjectHandler) GetAll(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("project-handler").Start(r.Context(), "GetAll")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	projects, err := h.service.GetProjects(ctx, userID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(projects); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func (h *ProjectHandler) Get(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("project-handler").Start(r.Context(), "Get")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	idStr := r.PathValue("id")
+	id64, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	id := uint(id64)
+
+	project, err := h.service.GetProject(ctx, userID, id)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(project); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func (h *ProjectHandler) Create(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("project-handler").Start(r.Context(), "Create")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	var dto ProjectDto
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	project, err := h.service.CreateProject(ctx, userID, dto)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(project); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func (h *ProjectHandler) Update(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("project-handler").Start(r.Context(), "Update")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	idStr := r.PathValue("id")
+	id64, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	id := uint(id64)
+
+	var dto ProjectDto
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	project, err := h.service.UpdateProject(ctx, userID, id, dto)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(project); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func (h *ProjectHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("project-handler").Start(r.Context(), "Delete")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	idStr := r.PathValue("id")
+	id64, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	id := uint(id64)
+
+	if err := h.service.DeleteProject(ctx, userID, id); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *ProjectHandler) Shortfall(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("project-handler").Start(r.Context(), "Shortfall")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	idStr := r.PathValue("id")
+	id64, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	id := uint(id64)
+
+	shortfall, err := h.service.GetShortfall(ctx, userID, id)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(shortfall); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// --- Tag Handler ---
+
+type TagHandler struct {
+	service *TagService
+}
+
+func NewTagHandler(service *TagService) *TagHandler {
+	return &TagHandler{service: service}
+}
+
+func (h *TagHandler) GetAll(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("tag-handler").Start(r.Context(), "GetAll")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	tags, err := h.service.GetTags(ctx, userID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tags); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func (h *TagHandler) Create(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("tag-handler").Start(r.Context(), "Create")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	var dto TagDto
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	tag, err := h.service.CreateTag(ctx, userID, dto)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(tag); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func (h *TagHandler) Update(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("tag-handler").Start(r.Context(), "Update")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	idStr := r.PathValue("id")
+	id64, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	id := uint(id64)
+
+	var dto TagDto
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	tag, err := h.service.UpdateTag(ctx, userID, id, dto)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tag); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func (h *TagHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("tag-handler").Start(r.Context(), "Delete")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	idStr := r.PathValue("id")
+	id64, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	id := uint(id64)
+
+	if err := h.service.DeleteTag(ctx, userID, id); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *TagHandler) TagThreads(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("tag-handler").Start(r.Context(), "TagThreads")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	var dto ThreadTagsDto
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.TagThreads(ctx, userID, dto); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *TagHandler) UntagThreads(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("tag-handler").Start(r.Context(), "UntagThreads")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	var dto ThreadTagsDto
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.UntagThreads(ctx, userID, dto); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- Location Handler ---
+
+type LocationHandler struct {
+	service *LocationService
+}
+
+func NewLocationHandler(service *LocationService) *LocationHandler {
+	return &LocationHandler{service: service}
+}
+
+func (h *LocationHandler) GetAll(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("location-handler").Start(r.Context(), "GetAll")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	tree, err := h.service.GetTree(ctx, userID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tree); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func (h *LocationHandler) Create(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("location-handler").Start(r.Context(), "Create")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	var dto LocationDto
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	location, err := h.service.CreateLocation(ctx, userID, dto)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(location); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func (h *LocationHandler) Update(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("location-handler").Start(r.Context(), "Update")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	idStr := r.PathValue("id")
+	id64, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	id := uint(id64)
+
+	var dto LocationDto
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	location, err := h.service.UpdateLocation(ctx, userID, id, dto)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(location); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func (h *LocationHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("location-handler").Start(r.Context(), "Delete")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	idStr := r.PathValue("id")
+	id64, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	id := uint(id64)
+
+	if err := h.service.DeleteLocation(ctx, userID, id); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *LocationHandler) MoveThreads(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("location-handler").Start(r.Context(), "MoveThreads")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	var dto MoveThreadsDto
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.service.MoveThreads(ctx, userID, dto)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func (h *LocationHandler) WhereIs(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("location-handler").Start(r.Context(), "WhereIs")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	brand := r.URL.Query().Get("brand")
+	number := r.URL.Query().Get("number")
+	if number == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "number is required"})
+		return
+	}
+
+	location, err := h.service.WhereIs(ctx, userID, brand, number)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(location); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// --- Shopping List Handler ---
+
+type ShoppingListHandler struct {
+	service *ShoppingListService
+}
+
+func NewShoppingListHandler(service *ShoppingListService) *ShoppingListHandler {
+	return &ShoppingListHandler{service: service}
+}
+
+func (h *ShoppingListHandler) Get(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("shopping-list-handler").Start(r.Context(), "Get")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" && format != "html" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "unsupported format"})
+		return
+	}
+	includeBought, _ := strconv.ParseBool(r.URL.Query().Get("include_bought"))
+
+	items, err := h.service.GetItems(ctx, userID, includeBought)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="shopping-list.csv"`)
+		err = writeShoppingListCSV(w, items)
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err = writeShoppingListHTML(w, items)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(items)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func (h *ShoppingListHandler) Add(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("shopping-list-handler").Start(r.Context(), "Add")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	var dto ShoppingListItemDto
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	item, err := h.service.AddItem(ctx, userID, dto)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(item); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func (h *ShoppingListHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("shopping-list-handler").Start(r.Context(), "Delete")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	idStr := r.PathValue("id")
+	id64, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	id := uint(id64)
+
+	if err := h.service.DeleteItem(ctx, userID, id); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *ShoppingListHandler) Receive(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("shopping-list-handler").Start(r.Context(), "Receive")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	var dto ShoppingListReceiveDto
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	receipt, err := h.service.Receive(ctx, userID, dto)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(receipt); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// --- Pattern Handler ---
+
+type PatternHandler struct {
+	service *PatternService
+}
+
+func NewPatternHandler(service *PatternService) *PatternHandler {
+	return &PatternHandler{service: service}
+}
+
+func (h *PatternHandler) Import(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("pattern-handler").Start(r.Context(), "Import")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "file is required"})
+		return
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	opts := PatternImportOptions{Brand: r.FormValue("brand")}
+	for field, dest := range map[string]*int{"fabric_count": &opts.FabricCount, "strands": &opts.Strands} {
+		if v := r.FormValue(field); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid " + field})
+				return
+			}
+			*dest = n
+		}
+	}
+
+	result, err := h.service.ImportOXS(ctx, userID, file, opts)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		if errors.Is(err, ErrInvalidOXS) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// Generate convertit une image envoyée en multipart (champ file) en grille de points.
+func (h *PatternHandler) Generate(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("pattern-handler").Start(r.Context(), "Generate")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "file is required"})
+		return
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	opts := ImagePatternOptions{
+		Title: strings.TrimSuffix(header.Filename, filepath.Ext(header.Filename)),
+		Brand: r.FormValue("brand"),
+	}
+	fields := map[string]*int{
+		"width":        &opts.Width,
+		"height":       &opts.Height,
+		"colors":       &opts.MaxColors,
+		"fabric_count": &opts.FabricCount,
+		"strands":      &opts.Strands,
+	}
+	for field, dest := range fields {
+		if v := r.FormValue(field); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid " + field})
+				return
+			}
+			*dest = n
+		}
+	}
+	if v := r.FormValue("dither"); v != "" {
+		dither, err := strconv.ParseBool(v)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid dither"})
+			return
+		}
+		opts.Dither = dither
+	}
+
+	result, err := h.service.GenerateFromImage(ctx, userID, file, opts)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		switch {
+		case errors.Is(err, ErrInvalidImage):
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "unknown brand"})
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// --- Chart Handler ---
+
+type ChartHandler struct {
+	service *ChartService
+}
+
+func NewChartHandler(service *ChartService) *ChartHandler {
+	return &ChartHandler{service: service}
+}
+
+func (h *ChartHandler) GetAll(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("chart-handler").Start(r.Context(), "GetAll")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	charts, err := h.service.GetCharts(ctx, userID)
+	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(charts); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func (h *ChartHandler) Get(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("chart-handler").Start(r.Context(), "Get")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	idStr := r.PathValue("id")
+	id64, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	id := uint(id64)
+
+	chart, err := h.service.GetChart(ctx, userID, id)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(chart); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func (h *ChartHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("chart-handler").Start(r.Context(), "Delete")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	idStr := r.PathValue("id")
+	id64, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	id := uint(id64)
+
+	if err := h.service.DeleteChart(ctx, userID, id); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeServiceError(w, err)
+		return
+	}
+
 	w.WriteHeader(http.StatusNoContent)
-}
\ No newline at end of file
+}
+
+// Render imprime la grille : ?format=svg|png|pdf, ?mode=symbol|color et,
+// pour SVG et PNG, ?page=N. Le nombre de pages est renvoyé dans X-Page-Count.
+func (h *ChartHandler) Render(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("chart-handler").Start(r.Context(), "Render")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	idStr := r.PathValue("id")
+	id64, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	id := uint(id64)
+
+	query := r.URL.Query()
+	opts := ChartRenderOptions{Format: query.Get("format"), Mode: query.Get("mode")}
+	if v := query.Get("page"); v != "" {
+		page, err := strconv.Atoi(v)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid page"})
+			return
+		}
+		opts.Page = page
+	}
+
+	rendered, err := h.service.Render(ctx, userID, id, opts)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", rendered.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, rendered.Filename))
+	w.Header().Set("X-Page-Count", strconv.Itoa(rendered.Pages))
+	if _, err := w.Write(rendered.Data); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// --- Calculator Handler ---
+
+type CalculatorHandler struct {
+	service *CalculatorService
+}
+
+func NewCalculatorHandler(service *CalculatorService) *CalculatorHandler {
+	return &CalculatorHandler{service: service}
+}
+
+func (h *CalculatorHandler) Skeins(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("calculator-handler").Start(r.Context(), "Skeins")
+	defer span.End()
+
+	var dto SkeinCalculationDto
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.service.CalculateSkeins(ctx, dto)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		if errors.Is(err, ErrInvalidCalculation) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// --- Catalog Handler ---
+
+type CatalogHandler struct {
+	service *CatalogService
+}
+
+func NewCatalogHandler(service *CatalogService) *CatalogHandler {
+	return &CatalogHandler{service: service}
+}
+
+func (h *CatalogHandler) GetBrands(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("catalog-handler").Start(r.Context(), "GetBrands")
+	defer span.End()
+
+	brands, err := h.service.GetBrands(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(brands); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func (h *CatalogHandler) GetColors(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("catalog-handler").Start(r.Context(), "GetColors")
+	defer span.End()
+
+	includeDiscontinued, _ := strconv.ParseBool(r.URL.Query().Get("include_discontinued"))
+	colors, err := h.service.GetColors(ctx, r.PathValue("brand"), r.URL.Query().Get("q"), includeDiscontinued)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(colors); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func (h *CatalogHandler) GetColor(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("catalog-handler").Start(r.Context(), "GetColor")
+	defer span.End()
+
+	color, err := h.service.GetColor(ctx, r.PathValue("brand"), r.PathValue("number"))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(color); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// --- Conversion Handler ---
+
+type ConversionHandler struct {
+	service *ConversionService
+}
+
+func NewConversionHandler(service *ConversionService) *ConversionHandler {
+	return &ConversionHandler{service: service}
+}
+
+func (h *ConversionHandler) Convert(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("conversion-handler").Start(r.Context(), "Convert")
+	defer span.End()
+
+	userID, _ := GetUserIDFromContext(ctx)
+	values := r.URL.Query()
+	from, id, to := values.Get("from"), values.Get("id"), values.Get("to")
+	if from == "" || id == "" || to == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "from, id and to are required"})
+		return
+	}
+
+	limit := 0
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	result, err := h.service.Convert(ctx, userID, from, id, to, limit)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"sort"
+)
+
+var ErrInvalidImage = errors.New("invalid image")
+
+const (
+	// maxPatternSize borne la grille générée, en points par côté
+	maxPatternSize = 400
+	// maxPatternColors borne la légende générée
+	maxPatternColors = 100
+	// maxImagePixels évite de décoder des images démesurées (« pixel bombs »)
+	maxImagePixels = 40_000_000
+)
+
+// PatternGrid est une grille de points : Cells contient, ligne par ligne,
+// l'index du coloris de la légende, ou -1 pour une case sans point.
+type PatternGrid struct {
+	Width  int
+	Height int
+	Cells  []int
+}
+
+func (g PatternGrid) At(x, y int) int {
+	return g.Cells[y*g.Width+x]
+}
+
+// Rows renvoie la grille sous forme de lignes, pour l'encodage JSON.
+func (g PatternGrid) Rows() [][]int {
+	rows := make([][]int, g.Height)
+	for y := range rows {
+		rows[y] = g.Cells[y*g.Width : (y+1)*g.Width]
+	}
+	return rows
+}
+
+type quantizeOptions struct {
+	Width     int
+	Height    int
+	MaxColors int
+	Dither    bool
+}
+
+// quantizedImage est le résultat de quantizeImage : Colors contient les index
+// de la palette d'origine utilisés par la grille, triés du plus au moins
+// utilisé, et Counts le nombre de points de chacun.
+type quantizedImage struct {
+	Grid   PatternGrid
+	Colors []int
+	Counts []int
+}
+
+// decodePatternImage lit une image PNG ou JPEG.
+func decodePatternImage(r io.Reader) (image.Image, error) {
+	// L'en-tête est décodé d'abord pour refuser les images trop grandes
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if format != "png" && format != "jpeg" {
+		return nil, fmt.Errorf("%w: unsupported format %s", ErrInvalidImage, format)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxImagePixels {
+		return nil, fmt.Errorf("%w: image is %dx%d pixels", ErrInvalidImage, cfg.Width, cfg.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	return img, nil
+}
+
+// patternSize complète la taille de grille demandée en conservant les
+// proportions de l'image quand une seule dimension est fournie.
+func patternSize(bounds image.Rectangle, width, height int) (int, int, error) {
+	w, h := bounds.Dx(), bounds.Dy()
+	switch {
+	case width <= 0 && height <= 0:
+		return 0, 0, fmt.Errorf("%w: width or height is required", ErrInvalidImage)
+	case width <= 0:
+		width = int(math.Max(1, math.Round(float64(height)*float64(w)/float64(h))))
+	case height <= 0:
+		height = int(math.Max(1, math.Round(float64(width)*float64(h)/float64(w))))
+	}
+	if width > maxPatternSize || height > maxPatternSize {
+		return 0, 0, fmt.Errorf("%w: grid cannot exceed %dx%d stitches", ErrInvalidImage, maxPatternSize, maxPatternSize)
+	}
+	return width, height, nil
+}
+
+// resizeImage réduit l'image à width x height cases en moyennant les pixels
+// couverts par chaque case. Les cases majoritairement transparentes sont nil.
+func resizeImage(img image.Image, width, height int) []*[3]uint8 {
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+
+	cells := make([]*[3]uint8, width*height)
+	for y := 0; y < height; y++ {
+		y0 := y * sh / height
+		y1 := max((y+1)*sh/height, y0+1)
+		for x := 0; x < width; x++ {
+			x0 := x * sw / width
+			x1 := max((x+1)*sw/width, x0+1)
+
+			// Les pixels de image.RGBA sont prémultipliés par l'alpha
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				i := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += uint64(src.Pix[i])
+					g += uint64(src.Pix[i+1])
+					b += uint64(src.Pix[i+2])
+					a += uint64(src.Pix[i+3])
+					n++
+					i += 4
+				}
+			}
+			if a*2 < n*255 {
+				continue
+			}
+			cells[y*width+x] = &[3]uint8{uint8(r * 255 / a), uint8(g * 255 / a), uint8(b * 255 / a)}
+		}
+	}
+	return cells
+}
+
+// nearestShortlist est le nombre de candidats, présélectionnés par distance
+// euclidienne dans Lab, départagés ensuite par CIEDE2000 : les deux mesures
+// s'accordent sur les couleurs proches et la seconde est bien plus coûteuse.
+const nearestShortlist = 8
+
+// nearestColor renvoie l'index de candidates le plus proche de c au sens de CIEDE2000.
+func nearestColor(c Lab, palette []Lab, candidates []int) int {
+	if len(candidates) > nearestShortlist {
+		candidates = shortlist(c, palette, candidates)
+	}
+	best, bestDelta := -1, math.Inf(1)
+	for _, i := range candidates {
+		if d := CIEDE2000(c, palette[i]); d < bestDelta {
+			best, bestDelta = i, d
+		}
+	}
+	return best
+}
+
+func shortlist(c Lab, palette []Lab, candidates []int) []int {
+	var kept [nearestShortlist]int
+	var dist [nearestShortlist]float64
+	n := 0
+	for _, i := range candidates {
+		p := palette[i]
+		d := (c.L-p.L)*(c.L-p.L) + (c.A-p.A)*(c.A-p.A) + (c.B-p.B)*(c.B-p.B)
+		if n == nearestShortlist && d >= dist[n-1] {
+			continue
+		}
+		if n < nearestShortlist {
+			n++
+		}
+		// Insertion dans la liste triée des plus proches
+		j := n - 1
+		for ; j > 0 && dist[j-1] > d; j-- {
+			kept[j], dist[j] = kept[j-1], dist[j-1]
+		}
+		kept[j], dist[j] = i, d
+	}
+	return kept[:n]
+}
+
+// quantizeImage convertit l'image en grille de points limitée à MaxColors
+// coloris de la palette. Chaque case reçoit d'abord le coloris le plus proche ;
+// les coloris les moins utilisés sont ensuite fusionnés avec leurs voisins, puis
+// la grille est recalculée avec les coloris retenus, par diffusion d'erreur
+// (Floyd-Steinberg) dans l'espace Lab avec Dither.
+func quantizeImage(img image.Image, palette []Lab, opts quantizeOptions) *quantizedImage {
+	cells := resizeImage(img, opts.Width, opts.Height)
+
+	labs := make([]*Lab, len(cells))
+	for i, c := range cells {
+		if c != nil {
+			lab := RGBToLab(c[0], c[1], c[2])
+			labs[i] = &lab
+		}
+	}
+
+	all := make([]int, len(palette))
+	for i := range all {
+		all[i] = i
+	}
+
+	// Les images réduites contiennent souvent des teintes répétées
+	assigned := make([]int, len(cells))
+	cache := map[[3]uint8]int{}
+	counts := map[int]int{}
+	for i, c := range cells {
+		if c == nil {
+			assigned[i] = -1
+			continue
+		}
+		idx, ok := cache[*c]
+		if !ok {
+			idx = nearestColor(*labs[i], palette, all)
+			cache[*c] = idx
+		}
+		assigned[i] = idx
+		counts[idx]++
+	}
+
+	// Le coloris le moins utilisé est fusionné avec son plus proche voisin
+	// restant, jusqu'à respecter la limite
+	for len(counts) > opts.MaxColors {
+		dropped, fewest := -1, math.MaxInt
+		for idx, n := range counts {
+			if n < fewest || (n == fewest && idx > dropped) {
+				dropped, fewest = idx, n
+			}
+		}
+		delete(counts, dropped)
+		target := nearestColor(palette[dropped], palette, sortedKeys(counts))
+		counts[target] += fewest
+	}
+
+	// Chaque case est ensuite réaffectée au plus proche des coloris retenus
+	active := sortedKeys(counts)
+	if opts.Dither && len(active) > 1 {
+		assigned, counts = ditherImage(labs, opts.Width, opts.Height, palette, active)
+	} else {
+		clear(cache)
+		clear(counts)
+		for i, c := range cells {
+			if c == nil {
+				continue
+			}
+			idx, ok := cache[*c]
+			if !ok {
+				idx = nearestColor(*labs[i], palette, active)
+				cache[*c] = idx
+			}
+			assigned[i] = idx
+			counts[idx]++
+		}
+	}
+
+	colors := make([]int, 0, len(counts))
+	for idx := range counts {
+		colors = append(colors, idx)
+	}
+	sort.Slice(colors, func(i, j int) bool {
+		if counts[colors[i]] != counts[colors[j]] {
+			return counts[colors[i]] > counts[colors[j]]
+		}
+		return colors[i] < colors[j]
+	})
+
+	result := &quantizedImage{
+		Grid:   PatternGrid{Width: opts.Width, Height: opts.Height, Cells: make([]int, len(cells))},
+		Colors: colors,
+		Counts: make([]int, len(colors)),
+	}
+	position := make(map[int]int, len(colors))
+	for i, idx := range colors {
+		position[idx] = i
+		result.Counts[i] = counts[idx]
+	}
+	for i, idx := range assigned {
+		if idx < 0 {
+			result.Grid.Cells[i] = -1
+			continue
+		}
+		result.Grid.Cells[i] = position[idx]
+	}
+	return result
+}
+
+func sortedKeys(m map[int]int) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// ditherImage répartit l'erreur de chaque case sur ses voisines non vides
+// encore à traiter, avec les poids de Floyd-Steinberg.
+func ditherImage(labs []*Lab, width, height int, palette []Lab, active []int) ([]int, map[int]int) {
+	errs := make([]Lab, len(labs))
+	assigned := make([]int, len(labs))
+	counts := map[int]int{}
+
+	spread := func(x, y int, e Lab, weight float64) {
+		if x < 0 || x >= width || y >= height {
+			return
+		}
+		i := y*width + x
+		if labs[i] == nil {
+			return
+		}
+		errs[i].L += e.L * weight
+		errs[i].A += e.A * weight
+		errs[i].B += e.B * weight
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			if labs[i] == nil {
+				assigned[i] = -1
+				continue
+			}
+			want := Lab{L: labs[i].L + errs[i].L, A: labs[i].A + errs[i].A, B: labs[i].B + errs[i].B}
+			idx := nearestColor(want, palette, active)
+			assigned[i] = idx
+			counts[idx]++
+
+			got := palette[idx]
+			e := Lab{L: want.L - got.L, A: want.A - got.A, B: want.B - got.B}
+			spread(x+1, y, e, 7.0/16)
+			spread(x-1, y+1, e, 3.0/16)
+			spread(x, y+1, e, 5.0/16)
+			spread(x+1, y+1, e, 1.0/16)
+		}
+	}
+	return assigned, counts
+}
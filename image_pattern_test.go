@@ -0,0 +1,220 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// quadrantImage renvoie une image size x size découpée en quatre quarts :
+// rouge, vert, bleu, et un quart en bas à droite entièrement transparent.
+func quadrantImage(size int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	half := size / 2
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			var c color.NRGBA
+			switch {
+			case x < half && y < half:
+				c = color.NRGBA{R: 255, A: 255}
+			case y < half:
+				c = color.NRGBA{G: 255, A: 255}
+			case x < half:
+				c = color.NRGBA{B: 255, A: 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// gradientImage renvoie un dégradé opaque aux teintes toutes différentes.
+func gradientImage(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 255 / (width - 1)), G: uint8(y * 255 / (height - 1)), B: 96, A: 255})
+		}
+	}
+	return img
+}
+
+func labPalette(colors ...[3]uint8) []Lab {
+	palette := make([]Lab, len(colors))
+	for i, c := range colors {
+		palette[i] = RGBToLab(c[0], c[1], c[2])
+	}
+	return palette
+}
+
+// cubePalette renvoie les 27 coloris dont chaque composante vaut 0, 128 ou 255.
+func cubePalette() []Lab {
+	levels := []uint8{0, 128, 255}
+	var colors [][3]uint8
+	for _, r := range levels {
+		for _, g := range levels {
+			for _, b := range levels {
+				colors = append(colors, [3]uint8{r, g, b})
+			}
+		}
+	}
+	return labPalette(colors...)
+}
+
+func TestResizeImage(t *testing.T) {
+	rgb := func(r, g, b uint8) *[3]uint8 { return &[3]uint8{r, g, b} }
+
+	mixed := image.NewNRGBA(image.Rect(0, 0, 4, 1))
+	mixed.SetNRGBA(0, 0, color.NRGBA{R: 255, A: 255})
+	mixed.SetNRGBA(1, 0, color.NRGBA{B: 255, A: 255})
+	// Case de droite : un pixel opaque et un pixel transparent, soit
+	// exactement la moitié de l'alpha, encore brodée
+	mixed.SetNRGBA(2, 0, color.NRGBA{G: 200, A: 255})
+
+	faint := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	faint.SetNRGBA(0, 0, color.NRGBA{R: 255, A: 254})
+
+	// Une sous-image ne commence pas à l'origine
+	offset := quadrantImage(8).SubImage(image.Rect(4, 0, 8, 4))
+
+	tests := []struct {
+		name          string
+		img           image.Image
+		width, height int
+		want          []*[3]uint8
+	}{
+		{"quadrants 2x2", quadrantImage(8), 2, 2, []*[3]uint8{rgb(255, 0, 0), rgb(0, 255, 0), rgb(0, 0, 255), nil}},
+		{"quadrants 1:1", quadrantImage(2), 2, 2, []*[3]uint8{rgb(255, 0, 0), rgb(0, 255, 0), rgb(0, 0, 255), nil}},
+		{"colours are averaged", mixed, 2, 1, []*[3]uint8{rgb(127, 0, 127), rgb(0, 200, 0)}},
+		{"mostly transparent cell is empty", faint, 1, 1, []*[3]uint8{nil}},
+		{"sub-image", offset, 2, 2, []*[3]uint8{rgb(0, 255, 0), rgb(0, 255, 0), rgb(0, 255, 0), rgb(0, 255, 0)}},
+		{"upscaled", quadrantImage(2), 4, 4, []*[3]uint8{
+			rgb(255, 0, 0), rgb(255, 0, 0), rgb(0, 255, 0), rgb(0, 255, 0),
+			rgb(255, 0, 0), rgb(255, 0, 0), rgb(0, 255, 0), rgb(0, 255, 0),
+			rgb(0, 0, 255), rgb(0, 0, 255), nil, nil,
+			rgb(0, 0, 255), rgb(0, 0, 255), nil, nil,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resizeImage(tt.img, tt.width, tt.height)
+			if len(got) != len(tt.want) {
+				t.Fatalf("len(cells) = %d, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				switch {
+				case got[i] == nil && tt.want[i] == nil:
+				case got[i] == nil || tt.want[i] == nil || *got[i] != *tt.want[i]:
+					t.Errorf("cell %d = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// checkQuantized vérifie les invariants de la légende : au plus MaxColors
+// coloris, triés du plus au moins utilisé, dont les décomptes correspondent
+// exactement aux cases de la grille.
+func checkQuantized(t *testing.T, q *quantizedImage, opts quantizeOptions, paletteSize int) {
+	t.Helper()
+	if q.Grid.Width != opts.Width || q.Grid.Height != opts.Height || len(q.Grid.Cells) != opts.Width*opts.Height {
+		t.Fatalf("grid is %dx%d with %d cells, want %dx%d", q.Grid.Width, q.Grid.Height, len(q.Grid.Cells), opts.Width, opts.Height)
+	}
+	if len(q.Colors) == 0 || len(q.Colors) > opts.MaxColors {
+		t.Errorf("len(Colors) = %d, want 1..%d", len(q.Colors), opts.MaxColors)
+	}
+	if len(q.Counts) != len(q.Colors) {
+		t.Fatalf("len(Counts) = %d, want %d", len(q.Counts), len(q.Colors))
+	}
+	seen := map[int]bool{}
+	for i, idx := range q.Colors {
+		if idx < 0 || idx >= paletteSize || seen[idx] {
+			t.Errorf("Colors[%d] = %d is out of range or repeated", i, idx)
+		}
+		seen[idx] = true
+		if i > 0 && q.Counts[i] > q.Counts[i-1] {
+			t.Errorf("Counts are not sorted: %v", q.Counts)
+		}
+	}
+	counts := make([]int, len(q.Colors))
+	for _, c := range q.Grid.Cells {
+		if c < -1 || c >= len(q.Colors) {
+			t.Fatalf("cell %d is not a key position", c)
+		}
+		if c >= 0 {
+			counts[c]++
+		}
+	}
+	for i := range counts {
+		if counts[i] != q.Counts[i] {
+			t.Errorf("Counts[%d] = %d, but the grid has %d stitches of that colour", i, q.Counts[i], counts[i])
+		}
+	}
+}
+
+func TestQuantizeImageQuadrants(t *testing.T) {
+	// Le rouge foncé et le blanc ne correspondent à aucune case
+	palette := labPalette([3]uint8{255, 255, 255}, [3]uint8{0, 0, 255}, [3]uint8{128, 0, 0}, [3]uint8{255, 0, 0}, [3]uint8{0, 255, 0})
+	const white, blue, darkRed, red, green = 0, 1, 2, 3, 4
+
+	tests := []struct {
+		name      string
+		maxColors int
+		dither    bool
+		colors    []int
+		counts    []int
+	}{
+		// À égalité de décompte, les coloris sont triés par index de palette
+		{"all colours", 5, false, []int{blue, red, green}, []int{4, 4, 4}},
+		{"exact cap", 3, false, []int{blue, red, green}, []int{4, 4, 4}},
+		{"exact cap with dither", 3, true, []int{blue, red, green}, []int{4, 4, 4}},
+		// Le coloris sacrifié est celui d'index le plus haut parmi les moins
+		// utilisés : le vert rejoint le bleu, plus proche que le rouge
+		{"two colours", 2, false, []int{blue, red}, []int{8, 4}},
+		{"single colour", 1, false, []int{blue}, []int{12}},
+		{"single colour with dither", 1, true, []int{blue}, []int{12}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := quantizeOptions{Width: 4, Height: 4, MaxColors: tt.maxColors, Dither: tt.dither}
+			q := quantizeImage(quadrantImage(8), palette, opts)
+			checkQuantized(t, q, opts, len(palette))
+
+			if len(q.Colors) != len(tt.colors) {
+				t.Fatalf("Colors = %v, want %v", q.Colors, tt.colors)
+			}
+			for i := range tt.colors {
+				if q.Colors[i] != tt.colors[i] || q.Counts[i] != tt.counts[i] {
+					t.Fatalf("Colors = %v, Counts = %v, want %v and %v", q.Colors, q.Counts, tt.colors, tt.counts)
+				}
+			}
+			// Le quart transparent reste vide quelle que soit la légende
+			for y := 0; y < 4; y++ {
+				for x := 0; x < 4; x++ {
+					if transparent := x >= 2 && y >= 2; transparent != (q.Grid.At(x, y) == -1) {
+						t.Errorf("cell (%d, %d) = %d, transparent = %v", x, y, q.Grid.At(x, y), transparent)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestQuantizeImageGradient(t *testing.T) {
+	palette := cubePalette()
+	for _, maxColors := range []int{1, 4, 8, 27} {
+		for _, dither := range []bool{false, true} {
+			opts := quantizeOptions{Width: 12, Height: 9, MaxColors: maxColors, Dither: dither}
+			q := quantizeImage(gradientImage(24, 18), palette, opts)
+			checkQuantized(t, q, opts, len(palette))
+
+			total := 0
+			for _, n := range q.Counts {
+				total += n
+			}
+			if total != opts.Width*opts.Height {
+				t.Errorf("colors=%d dither=%v: %d stitches, want %d", maxColors, dither, total, opts.Width*opts.Height)
+			}
+		}
+	}
+}
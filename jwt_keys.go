@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// defaultKeyGracePeriod est la durée pendant laquelle une clé retirée vérifie
+// encore les jetons ; elle doit dépasser la durée de vie d'un jeton d'accès.
+const defaultKeyGracePeriod = time.Hour
+
+// SigningKey est une clé de signature des jetons, identifiée par son kid.
+// RetiredAt est renseigné pour une clé qui ne signe plus.
+type SigningKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	Private   crypto.PrivateKey
+	Public    crypto.PublicKey
+	RetiredAt *time.Time
+}
+
+// KeyRing signe les jetons avec la clé active et les vérifie avec toute clé
+// encore dans sa période de grâce.
+type KeyRing struct {
+	keys  []*SigningKey
+	grace time.Duration
+}
+
+// JWK est la forme publique d'une clé, telle que publiée dans le JWKS.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// LoadKeyRing lit JWT_KEYS, une liste séparée par des virgules d'entrées
+// kid=fichier.pem, suivies de @date (RFC 3339) pour une clé retirée. La
+// première clé non retirée signe ; les autres restent vérifiables. Sans
+// JWT_KEYS, SECRET_KEY sert de clé HS256 sans kid, comme auparavant.
+func LoadKeyRing() (*KeyRing, error) {
+	ring := &KeyRing{grace: defaultKeyGracePeriod}
+	if v := os.Getenv("JWT_KEY_GRACE_PERIOD"); v != "" {
+		grace, err := time.ParseDuration(v)
+		if err != nil || grace < 0 {
+			return nil, fmt.Errorf("invalid JWT_KEY_GRACE_PERIOD %q", v)
+		}
+		ring.grace = grace
+	}
+
+	spec := strings.TrimSpace(os.Getenv("JWT_KEYS"))
+	if spec == "" {
+		secret := os.Getenv("SECRET_KEY")
+		if secret == "" {
+			return nil, errors.New("JWT_KEYS or SECRET_KEY must be set")
+		}
+		ring.keys = []*SigningKey{{Method: jwt.SigningMethodHS256, Private: []byte(secret), Public: []byte(secret)}}
+		return ring, nil
+	}
+
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(spec, ",") {
+		key, err := parseKeyEntry(strings.TrimSpace(entry))
+		if err != nil {
+			return nil, err
+		}
+		if seen[key.ID] {
+			return nil, fmt.Errorf("duplicate JWT key id %q", key.ID)
+		}
+		seen[key.ID] = true
+		ring.keys = append(ring.keys, key)
+	}
+	if ring.active() == nil {
+		return nil, errors.New("JWT_KEYS has no active key")
+	}
+	return ring, nil
+}
+
+func parseKeyEntry(entry string) (*SigningKey, error) {
+	kid, rest, ok := strings.Cut(entry, "=")
+	if !ok || kid == "" {
+		return nil, fmt.Errorf("invalid JWT key entry %q, expected kid=path", entry)
+	}
+	path, retired, hasRetired := strings.Cut(rest, "@")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read JWT key %s: %w", kid, err)
+	}
+	key, err := parsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse JWT key %s: %w", kid, err)
+	}
+	key.ID = kid
+
+	if hasRetired {
+		at, err := time.Parse(time.RFC3339, retired)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retirement date for JWT key %s: %w", kid, err)
+		}
+		key.RetiredAt = &at
+	}
+	return key, nil
+}
+
+// parsePrivateKey lit une clé privée PEM, PKCS#8 ou PKCS#1 ; l'algorithme
+// découle du type de clé.
+func parsePrivateKey(data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	var parsed any
+	var err error
+	if block.Type == "RSA PRIVATE KEY" {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := parsed.(type) {
+	case ed25519.PrivateKey:
+		return &SigningKey{Method: jwt.SigningMethodEdDSA, Private: k, Public: k.Public()}, nil
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must be at least 2048 bits")
+		}
+		return &SigningKey{Method: jwt.SigningMethodRS256, Private: k, Public: &k.PublicKey}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, expected Ed25519 or RSA", parsed)
+	}
+}
+
+func (k *KeyRing) active() *SigningKey {
+	for _, key := range k.keys {
+		if key.RetiredAt == nil {
+			return key
+		}
+	}
+	return nil
+}
+
+// usable indique si la clé vérifie encore des jetons à l'instant now.
+func (k *KeyRing) usable(key *SigningKey, now time.Time) bool {
+	return key.RetiredAt == nil || now.Before(key.RetiredAt.Add(k.grace))
+}
+
+// Sign signe les claims avec la clé active, dont le kid est placé en en-tête.
+func (k *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	key := k.active()
+	token := jwt.NewWithClaims(key.Method, claims)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+	return token.SignedString(key.Private)
+}
+
+// Keyfunc choisit la clé de vérification d'après le kid du jeton. L'algorithme
+// annoncé doit être celui de la clé, pour qu'une clé publique ne puisse pas
+// servir de secret HMAC.
+func (k *KeyRing) Keyfunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	now := time.Now()
+	for _, key := range k.keys {
+		if key.ID != kid {
+			continue
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, errors.New("unexpected signing method")
+		}
+		if !k.usable(key, now) {
+			return nil, errors.New("signing key has been retired")
+		}
+		return key.Public, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// JWKS renvoie les clés publiques encore utilisables. Les secrets HMAC ne
+// sont jamais publiés.
+func (k *KeyRing) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	now := time.Now()
+	for _, key := range k.keys {
+		if !k.usable(key, now) {
+			continue
+		}
+		jwk := JWK{Use: "sig", Alg: key.Method.Alg(), Kid: key.ID}
+		switch pub := key.Public.(type) {
+		case ed25519.PublicKey:
+			jwk.Kty, jwk.Crv = "OKP", "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
@@ -7,6 +7,7 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
@@ -35,22 +36,109 @@ func main() {
 
 	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{AddSource: true}))
 
-	if err := db.AutoMigrate(&User{}, &Thread{}, &PasswordResetToken{}); err != nil {
+	if err := MigrateThreadIdentity(db, logger); err != nil {
+		fmt.Printf("Failed to migrate thread identity: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := MigrateEmailVerification(db, logger); err != nil {
+		fmt.Printf("Failed to migrate email verification: %v\n", err)
+		os.Exit(1)
+	}
+
+	// La liste de courses est remplie à sa création avec les fils déjà à zéro
+	newShoppingList := !db.Migrator().HasTable(&ShoppingListItem{})
+
+	if err := db.AutoMigrate(&User{}, &Thread{}, &StockMovement{}, &Brand{}, &CatalogColor{}, &ColorConversion{}, &Project{}, &ProjectRequirement{}, &ShoppingListItem{}, &Location{}, &Tag{}, &Chart{}, &PasswordResetToken{}, &EmailVerificationToken{}, &EmailChangeRequest{}, &Session{}, &RefreshToken{}); err != nil {
 		fmt.Printf("Failed to migrate database: %v\n", err)
 		os.Exit(1)
 	}
 
+	if err := MigrateThreadFormats(db, logger); err != nil {
+		fmt.Printf("Failed to migrate thread formats: %v\n", err)
+		os.Exit(1)
+	}
+
+	if newShoppingList {
+		if err := BackfillShoppingList(db, logger); err != nil {
+			fmt.Printf("Failed to backfill shopping list: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	// Dependency Injection
 	accountRepo := NewAccountRepository(db)
 	resetRepo := NewPasswordResetRepository(db)
+	verifyRepo := NewEmailVerificationRepository(db)
+	changeRepo := NewEmailChangeRepository(db)
+	sessionRepo := NewSessionRepository(db)
+	refreshRepo := NewRefreshTokenRepository(db)
+	transactor := NewTransactor(db)
 	emailService := NewEmailService(logger)
-	accountService := NewAccountService(accountRepo, resetRepo, emailService, logger)
-	accountHandler := NewAccountHandler(accountService)
+	keyRing, err := LoadKeyRing()
+	if err != nil {
+		fmt.Printf("Failed to load JWT signing keys: %v\n", err)
+		os.Exit(1)
+	}
+	sessionService := NewSessionService(sessionRepo, refreshRepo, transactor, keyRing, logger)
+	auth := NewAuthMiddleware(sessionService)
+	accountService := NewAccountService(accountRepo, resetRepo, verifyRepo, changeRepo, sessionService, emailService, logger)
+	trustedProxies, err := LoadTrustedProxies()
+	if err != nil {
+		fmt.Printf("Failed to load trusted proxies: %v\n", err)
+		os.Exit(1)
+	}
+	accountHandler := NewAccountHandler(accountService, sessionService, trustedProxies)
+
+	catalogRepo := NewCatalogRepository(db)
+	if err := SeedCatalog(ctx, catalogRepo, transactor); err != nil {
+		fmt.Printf("Failed to seed catalog: %v\n", err)
+		os.Exit(1)
+	}
+	catalogService := NewCatalogService(catalogRepo, logger)
+	if err := MigrateThreadCatalog(ctx, db, catalogService, logger); err != nil {
+		fmt.Printf("Failed to link threads to the catalog: %v\n", err)
+		os.Exit(1)
+	}
+	catalogHandler := NewCatalogHandler(catalogService)
+	calculatorService := NewCalculatorService(catalogService, logger)
+	calculatorHandler := NewCalculatorHandler(calculatorService)
 
 	threadRepo := NewThreadRepository(db)
-	threadService := NewThreadService(threadRepo, logger)
+	movementRepo := NewStockMovementRepository(db)
+	shoppingRepo := NewShoppingListRepository(db)
+	locationRepo := NewLocationRepository(db)
+	threadService := NewThreadService(threadRepo, movementRepo, accountRepo, shoppingRepo, locationRepo, catalogService, transactor, emailService, logger)
 	threadHandler := NewThreadHandler(threadService)
 
+	if retention := GetTrashRetention(); retention > 0 {
+		go threadService.RunTrashRetention(ctx, retention, time.Hour)
+	}
+
+	projectRepo := NewProjectRepository(db)
+	projectService := NewProjectService(projectRepo, threadRepo, catalogService, transactor, logger)
+	projectHandler := NewProjectHandler(projectService)
+
+	tagRepo := NewTagRepository(db)
+	tagService := NewTagService(tagRepo, threadRepo, transactor, logger)
+	tagHandler := NewTagHandler(tagService)
+
+	locationService := NewLocationService(locationRepo, threadRepo, catalogService, transactor, logger)
+	locationHandler := NewLocationHandler(locationService)
+
+	shoppingService := NewShoppingListService(shoppingRepo, threadService, catalogService, transactor, logger)
+	shoppingHandler := NewShoppingListHandler(shoppingService)
+
+	chartRepo := NewChartRepository(db)
+	patternService := NewPatternService(threadRepo, chartRepo, catalogService, logger)
+	patternHandler := NewPatternHandler(patternService)
+
+	chartService := NewChartService(chartRepo, threadRepo, logger)
+	chartHandler := NewChartHandler(chartService)
+
+	conversionService := NewConversionService(catalogRepo, threadRepo, logger)
+	conversionHandler := NewConversionHandler(conversionService)
+
 	// Router
 	mux := http.NewServeMux()
 
@@ -58,22 +146,79 @@ func main() {
 	mux.Handle("POST /login", otelhttp.NewHandler(http.HandlerFunc(accountHandler.Login), "Login"))
 	mux.Handle("POST /register", otelhttp.NewHandler(http.HandlerFunc(accountHandler.Register), "Register"))
 	mux.Handle("POST /logout", otelhttp.NewHandler(http.HandlerFunc(accountHandler.Logout), "Logout"))
+	mux.Handle("POST /token/refresh", otelhttp.NewHandler(http.HandlerFunc(accountHandler.Refresh), "RefreshToken"))
+	mux.Handle("GET /.well-known/jwks.json", otelhttp.NewHandler(http.HandlerFunc(accountHandler.JWKS), "JWKS"))
 	mux.Handle("POST /forgot-password", otelhttp.NewHandler(http.HandlerFunc(accountHandler.ForgotPassword), "ForgotPassword"))
 	mux.Handle("POST /reset-password", otelhttp.NewHandler(http.HandlerFunc(accountHandler.ResetPassword), "ResetPassword"))
+	mux.Handle("POST /verify-email", otelhttp.NewHandler(http.HandlerFunc(accountHandler.VerifyEmail), "VerifyEmail"))
+	mux.Handle("POST /confirm-email-change", otelhttp.NewHandler(http.HandlerFunc(accountHandler.ConfirmEmailChange), "ConfirmEmailChange"))
+	mux.Handle("POST /cancel-email-change", otelhttp.NewHandler(http.HandlerFunc(accountHandler.CancelEmailChange), "CancelEmailChange"))
 	mux.Handle("POST /contact", otelhttp.NewHandler(http.HandlerFunc(accountHandler.Contact), "Contact"))
 
 	// Protected routes
-	mux.Handle("GET /users/me", Auth(otelhttp.NewHandler(http.HandlerFunc(accountHandler.Me), "Me")))
-	mux.Handle("PUT /users/update-password", Auth(otelhttp.NewHandler(http.HandlerFunc(accountHandler.UpdatePassword), "UpdatePassword")))
-	mux.Handle("GET /threads", Auth(otelhttp.NewHandler(http.HandlerFunc(threadHandler.GetAll), "GetAllThreads")))
-	mux.Handle("POST /threads/create", Auth(otelhttp.NewHandler(http.HandlerFunc(threadHandler.Create), "CreateThread")))
-	mux.Handle("DELETE /threads/delete", Auth(otelhttp.NewHandler(http.HandlerFunc(threadHandler.DeleteMultiple), "DeleteMultipleThreads")))
-	mux.Handle("PUT /threads/update/{id}", Auth(otelhttp.NewHandler(http.HandlerFunc(threadHandler.Update), "UpdateThread")))
-	mux.Handle("DELETE /threads/delete/{id}", Auth(otelhttp.NewHandler(http.HandlerFunc(threadHandler.Delete), "DeleteThread")))
+	mux.Handle("GET /users/me", auth.Require(otelhttp.NewHandler(http.HandlerFunc(accountHandler.Me), "Me")))
+	mux.Handle("POST /users/me/verification-email", auth.Require(otelhttp.NewHandler(http.HandlerFunc(accountHandler.ResendVerification), "ResendVerification")))
+	mux.Handle("PUT /users/me/email", auth.Require(otelhttp.NewHandler(http.HandlerFunc(accountHandler.ChangeEmail), "ChangeEmail")))
+	mux.Handle("PUT /users/update-password", auth.Require(otelhttp.NewHandler(http.HandlerFunc(accountHandler.UpdatePassword), "UpdatePassword")))
+	mux.Handle("GET /users/me/sessions", auth.Require(otelhttp.NewHandler(http.HandlerFunc(accountHandler.Sessions), "GetSessions")))
+	mux.Handle("DELETE /users/me/sessions", auth.Require(otelhttp.NewHandler(http.HandlerFunc(accountHandler.RevokeOtherSessions), "RevokeOtherSessions")))
+	mux.Handle("DELETE /users/me/sessions/{id}", auth.Require(otelhttp.NewHandler(http.HandlerFunc(accountHandler.RevokeSession), "RevokeSession")))
+	mux.Handle("GET /threads", auth.Require(otelhttp.NewHandler(http.HandlerFunc(threadHandler.GetAll), "GetAllThreads")))
+	mux.Handle("POST /threads/create", auth.Require(otelhttp.NewHandler(http.HandlerFunc(threadHandler.Create), "CreateThread")))
+	mux.Handle("DELETE /threads/delete", auth.Require(otelhttp.NewHandler(http.HandlerFunc(threadHandler.DeleteMultiple), "DeleteMultipleThreads")))
+	mux.Handle("PUT /threads/update/{id}", auth.Require(otelhttp.NewHandler(http.HandlerFunc(threadHandler.Update), "UpdateThread")))
+	mux.Handle("PATCH /threads/{id}", auth.Require(otelhttp.NewHandler(http.HandlerFunc(threadHandler.Patch), "PatchThread")))
+	mux.Handle("GET /threads/low-stock", auth.Require(otelhttp.NewHandler(http.HandlerFunc(threadHandler.LowStock), "GetLowStockThreads")))
+	mux.Handle("PUT /users/me/low-stock", auth.Require(otelhttp.NewHandler(http.HandlerFunc(threadHandler.SetLowStockDefault), "SetLowStockDefault")))
+	mux.Handle("GET /threads/trash", auth.Require(otelhttp.NewHandler(http.HandlerFunc(threadHandler.Trash), "GetThreadTrash")))
+	mux.Handle("POST /threads/{id}/restore", auth.Require(otelhttp.NewHandler(http.HandlerFunc(threadHandler.Restore), "RestoreThread")))
+	// "DELETE /threads/{id}/purge" chevaucherait "DELETE /threads/delete/{id}" pour le ServeMux,
+	// l'action est donc un wildcard vérifiée par le handler
+	mux.Handle("DELETE /threads/{id}/{action}", auth.Require(otelhttp.NewHandler(http.HandlerFunc(threadHandler.Purge), "PurgeThread")))
+	mux.Handle("POST /threads/batch", auth.Require(otelhttp.NewHandler(http.HandlerFunc(threadHandler.Batch), "BatchThreads")))
+	mux.Handle("POST /threads/import", auth.Require(otelhttp.NewHandler(http.HandlerFunc(threadHandler.Import), "ImportThreads")))
+	mux.Handle("GET /threads/export", auth.Require(otelhttp.NewHandler(http.HandlerFunc(threadHandler.Export), "ExportThreads")))
+	mux.Handle("GET /threads/similar", auth.Require(otelhttp.NewHandler(http.HandlerFunc(threadHandler.SimilarToHex), "SimilarThreadsToHex")))
+	mux.Handle("GET /threads/{id}/similar", auth.Require(otelhttp.NewHandler(http.HandlerFunc(threadHandler.SimilarToThread), "SimilarThreadsToThread")))
+	mux.Handle("GET /threads/{id}/movements", auth.Require(otelhttp.NewHandler(http.HandlerFunc(threadHandler.Movements), "ThreadMovements")))
+	mux.Handle("DELETE /threads/delete/{id}", auth.Require(otelhttp.NewHandler(http.HandlerFunc(threadHandler.Delete), "DeleteThread")))
+	mux.Handle("GET /projects", auth.Require(otelhttp.NewHandler(http.HandlerFunc(projectHandler.GetAll), "GetAllProjects")))
+	mux.Handle("POST /projects", auth.Require(otelhttp.NewHandler(http.HandlerFunc(projectHandler.Create), "CreateProject")))
+	mux.Handle("GET /projects/{id}", auth.Require(otelhttp.NewHandler(http.HandlerFunc(projectHandler.Get), "GetProject")))
+	mux.Handle("PUT /projects/{id}", auth.Require(otelhttp.NewHandler(http.HandlerFunc(projectHandler.Update), "UpdateProject")))
+	mux.Handle("DELETE /projects/{id}", auth.Require(otelhttp.NewHandler(http.HandlerFunc(projectHandler.Delete), "DeleteProject")))
+	mux.Handle("GET /projects/{id}/shortfall", auth.Require(otelhttp.NewHandler(http.HandlerFunc(projectHandler.Shortfall), "ProjectShortfall")))
+	mux.Handle("GET /tags", auth.Require(otelhttp.NewHandler(http.HandlerFunc(tagHandler.GetAll), "GetAllTags")))
+	mux.Handle("POST /tags", auth.Require(otelhttp.NewHandler(http.HandlerFunc(tagHandler.Create), "CreateTag")))
+	mux.Handle("PUT /tags/{id}", auth.Require(otelhttp.NewHandler(http.HandlerFunc(tagHandler.Update), "UpdateTag")))
+	mux.Handle("DELETE /tags/{id}", auth.Require(otelhttp.NewHandler(http.HandlerFunc(tagHandler.Delete), "DeleteTag")))
+	mux.Handle("POST /threads/tags", auth.Require(otelhttp.NewHandler(http.HandlerFunc(tagHandler.TagThreads), "TagThreads")))
+	mux.Handle("DELETE /threads/tags", auth.Require(otelhttp.NewHandler(http.HandlerFunc(tagHandler.UntagThreads), "UntagThreads")))
+	mux.Handle("GET /locations", auth.Require(otelhttp.NewHandler(http.HandlerFunc(locationHandler.GetAll), "GetAllLocations")))
+	mux.Handle("POST /locations", auth.Require(otelhttp.NewHandler(http.HandlerFunc(locationHandler.Create), "CreateLocation")))
+	mux.Handle("PUT /locations/{id}", auth.Require(otelhttp.NewHandler(http.HandlerFunc(locationHandler.Update), "UpdateLocation")))
+	mux.Handle("DELETE /locations/{id}", auth.Require(otelhttp.NewHandler(http.HandlerFunc(locationHandler.Delete), "DeleteLocation")))
+	mux.Handle("POST /threads/move", auth.Require(otelhttp.NewHandler(http.HandlerFunc(locationHandler.MoveThreads), "MoveThreads")))
+	mux.Handle("GET /threads/where-is", auth.Require(otelhttp.NewHandler(http.HandlerFunc(locationHandler.WhereIs), "WhereIsThread")))
+	mux.Handle("GET /shopping-list", auth.Require(otelhttp.NewHandler(http.HandlerFunc(shoppingHandler.Get), "GetShoppingList")))
+	mux.Handle("POST /shopping-list", auth.Require(otelhttp.NewHandler(http.HandlerFunc(shoppingHandler.Add), "AddShoppingListItem")))
+	mux.Handle("DELETE /shopping-list/{id}", auth.Require(otelhttp.NewHandler(http.HandlerFunc(shoppingHandler.Delete), "DeleteShoppingListItem")))
+	mux.Handle("POST /shopping-list/receive", auth.Require(otelhttp.NewHandler(http.HandlerFunc(shoppingHandler.Receive), "ReceiveShoppingList")))
+	mux.Handle("POST /patterns/import", auth.Require(otelhttp.NewHandler(http.HandlerFunc(patternHandler.Import), "ImportPattern")))
+	mux.Handle("POST /patterns/generate", auth.Require(otelhttp.NewHandler(http.HandlerFunc(patternHandler.Generate), "GeneratePattern")))
+	mux.Handle("GET /charts", auth.Require(otelhttp.NewHandler(http.HandlerFunc(chartHandler.GetAll), "GetCharts")))
+	mux.Handle("GET /charts/{id}", auth.Require(otelhttp.NewHandler(http.HandlerFunc(chartHandler.Get), "GetChart")))
+	mux.Handle("DELETE /charts/{id}", auth.Require(otelhttp.NewHandler(http.HandlerFunc(chartHandler.Delete), "DeleteChart")))
+	mux.Handle("GET /charts/{id}/render", auth.Require(otelhttp.NewHandler(http.HandlerFunc(chartHandler.Render), "RenderChart")))
+	mux.Handle("GET /catalog/brands", auth.Require(otelhttp.NewHandler(http.HandlerFunc(catalogHandler.GetBrands), "GetCatalogBrands")))
+	mux.Handle("GET /catalog/brands/{brand}/colors", auth.Require(otelhttp.NewHandler(http.HandlerFunc(catalogHandler.GetColors), "GetCatalogColors")))
+	mux.Handle("GET /catalog/brands/{brand}/colors/{number}", auth.Require(otelhttp.NewHandler(http.HandlerFunc(catalogHandler.GetColor), "GetCatalogColor")))
+	mux.Handle("POST /calculator/skeins", auth.Require(otelhttp.NewHandler(http.HandlerFunc(calculatorHandler.Skeins), "CalculateSkeins")))
+	mux.Handle("GET /catalog/convert", auth.Require(otelhttp.NewHandler(http.HandlerFunc(conversionHandler.Convert), "ConvertColor")))
 
 	slog.Info("Server listening on :8080")
 	if err := http.ListenAndServe(":8080", mux); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		fmt.Printf("HTTP server error: %v\n", err)
 		os.Exit(1)
 	}
-}
\ No newline at end of file
+}
@@ -2,19 +2,60 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
 	"net/http"
-	"strconv"
+	"net/netip"
+	"os"
 	"strings"
-
-	"github.com/golang-jwt/jwt/v5"
 )
 
 type contextKey string
 
-const UserIDKey contextKey = "userID"
+const (
+	UserIDKey    contextKey = "userID"
+	SessionIDKey contextKey = "sessionID"
+)
+
+// defaultUnverifiedRoutes sont les routes protégées ouvertes aux comptes dont
+// l'adresse n'est pas encore vérifiée.
+var defaultUnverifiedRoutes = []string{
+	"GET /users/me",
+	"POST /users/me/verification-email",
+	"PUT /users/me/email",
+	"PUT /users/update-password",
+	"GET /users/me/sessions",
+	"DELETE /users/me/sessions",
+	"DELETE /users/me/sessions/{id}",
+}
+
+type AuthMiddleware struct {
+	sessions   *SessionService
+	unverified map[string]bool
+}
+
+// NewAuthMiddleware lit dans UNVERIFIED_ALLOWED_ROUTES la liste, séparée par
+// des virgules, des motifs de routes (« GET /users/me ») accessibles sans
+// adresse vérifiée ; defaultUnverifiedRoutes s'applique sinon.
+func NewAuthMiddleware(sessions *SessionService) *AuthMiddleware {
+	routes := defaultUnverifiedRoutes
+	if v := os.Getenv("UNVERIFIED_ALLOWED_ROUTES"); v != "" {
+		routes = strings.Split(v, ",")
+	}
+	unverified := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		if route = strings.Join(strings.Fields(route), " "); route != "" {
+			unverified[route] = true
+		}
+	}
+	return &AuthMiddleware{sessions: sessions, unverified: unverified}
+}
 
-func Auth(next http.Handler) http.Handler {
+// Require refuse les requêtes sans jeton valide ou dont la session a été
+// révoquée, ainsi que celles d'un compte non vérifié hors des routes permises.
+func (m *AuthMiddleware) Require(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		tokenString, err := getTokenFromRequest(r)
 		if err != nil {
@@ -22,37 +63,25 @@ func Auth(next http.Handler) http.Handler {
 			return
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, errors.New("unexpected signing method")
-			}
-			return GetSecretKey(), nil
-		})
-
-		if err != nil || !token.Valid {
+		session, err := m.sessions.Authenticate(r.Context(), tokenString)
+		if errors.Is(err, ErrInvalidSession) {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
-
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			w.WriteHeader(http.StatusUnauthorized)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-
-		sub, ok := claims["sub"].(string)
-		if !ok {
-			w.WriteHeader(http.StatusUnauthorized)
+		// r.Pattern est le motif sous lequel la route est enregistrée
+		if session.User.EmailVerifiedAt == nil && !m.unverified[r.Pattern] {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "email address not verified"})
 			return
 		}
 
-		var userID uint
-		id64, err := strconv.ParseUint(sub, 10, 32)
-		if err == nil {
-			userID = uint(id64)
-		}
-
-		ctx := context.WithValue(r.Context(), UserIDKey, userID)
+		ctx := context.WithValue(r.Context(), UserIDKey, session.UserID)
+		ctx = context.WithValue(ctx, SessionIDKey, session.ID)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -62,6 +91,11 @@ func GetUserIDFromContext(ctx context.Context) (uint, bool) {
 	return userID, ok
 }
 
+func GetSessionIDFromContext(ctx context.Context) (uint, bool) {
+	sessionID, ok := ctx.Value(SessionIDKey).(uint)
+	return sessionID, ok
+}
+
 func getTokenFromRequest(r *http.Request) (string, error) {
 	// Check cookie
 	cookie, err := r.Cookie("token")
@@ -80,3 +114,75 @@ func getTokenFromRequest(r *http.Request) (string, error) {
 
 	return "", errors.New("no token found")
 }
+
+// TrustedProxies sont les proxys dont on accepte l'en-tête X-Forwarded-For.
+type TrustedProxies []netip.Prefix
+
+// LoadTrustedProxies lit TRUSTED_PROXIES, adresses ou plages CIDR séparées par
+// des virgules (« 127.0.0.1,10.0.0.0/8 »). Sans proxy de confiance,
+// X-Forwarded-For est ignoré.
+func LoadTrustedProxies() (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, v := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+			}
+			addr = addr.Unmap()
+			proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+func (p TrustedProxies) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP renvoie l'adresse du client. X-Forwarded-For n'est lu que si la
+// connexion vient d'un proxy de confiance : on remonte alors la liste depuis
+// la droite jusqu'au premier saut qui n'en est pas un, les entrées plus à
+// gauche pouvant être forgées par le client.
+func (p TrustedProxies) ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil || !p.contains(ip) {
+		return host
+	}
+
+	// Plusieurs en-têtes X-Forwarded-For se lisent à la suite
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		ip = hop
+		if !p.contains(hop) {
+			break
+		}
+	}
+	return ip.Unmap().String()
+}
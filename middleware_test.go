@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoadTrustedProxies(t *testing.T) {
+	tests := []struct {
+		env     string
+		want    int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"127.0.0.1", 1, false},
+		{" 10.0.0.0/8 , ::1,", 2, false},
+		{"10.0.0.1/8", 1, false},
+		{"proxy.local", 0, true},
+		{"10.0.0.0/33", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			t.Setenv("TRUSTED_PROXIES", tt.env)
+			proxies, err := LoadTrustedProxies()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadTrustedProxies() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(proxies) != tt.want {
+				t.Errorf("len(proxies) = %d, want %d", len(proxies), tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8,::1")
+	proxies, err := LoadTrustedProxies()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		proxies   TrustedProxies
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"no proxy configured ignores the header", nil, "203.0.113.7:4242", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"untrusted peer ignores the header", proxies, "203.0.113.7:4242", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted peer without header", proxies, "10.0.0.2:4242", nil, "10.0.0.2"},
+		{"trusted peer", proxies, "10.0.0.2:4242", []string{"198.51.100.1"}, "198.51.100.1"},
+		// Le client a forgé la première entrée, le proxy a ajouté la vraie adresse
+		{"spoofed left-most entry", proxies, "10.0.0.2:4242", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", proxies, "10.0.0.2:4242", []string{"1.2.3.4, 198.51.100.1, 10.1.1.1"}, "198.51.100.1"},
+		{"several headers", proxies, "10.0.0.2:4242", []string{"1.2.3.4", "198.51.100.1, 10.1.1.1"}, "198.51.100.1"},
+		{"only trusted hops", proxies, "10.0.0.2:4242", []string{"10.3.3.3, 10.1.1.1"}, "10.3.3.3"},
+		{"garbage stops at the last valid hop", proxies, "10.0.0.2:4242", []string{"198.51.100.1, bogus, 10.1.1.1"}, "10.1.1.1"},
+		{"ipv6 proxy", proxies, "[::1]:4242", []string{"2001:db8::1"}, "2001:db8::1"},
+		{"ipv4-mapped proxy", proxies, "[::ffff:10.0.0.2]:4242", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"remote without port", proxies, "203.0.113.7", []string{"198.51.100.1"}, "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/login", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := tt.proxies.ClientIP(r); got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
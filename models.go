@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"time"
 
 	"gorm.io/gorm"
@@ -13,18 +14,224 @@ type User struct {
 	Password string   `json:"-"`
 	Email    string   `gorm:"unique" json:"email"`
 	Threads  []Thread `gorm:"foreignKey:UserID" json:"threads"`
+	// Seuil de stock bas appliqué aux fils sans MinQuantity (0 : désactivé)
+	DefaultMinQuantity int64 `gorm:"default:0" json:"default_min_quantity"`
+	// EmailVerifiedAt reste nul tant que l'adresse n'a pas été confirmée
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
 }
 
 type Thread struct {
 	gorm.Model
-	UserID      uint   `gorm:"uniqueIndex:idx_user_thread" json:"user_id"`
-	User        User   `gorm:"foreignKey:UserID" json:"-"`
-	ThreadId    string `gorm:"uniqueIndex:idx_user_thread" json:"thread_id"`
-	IsE         bool   `json:"is_e"`
-	IsC         bool   `json:"is_c"`
-	IsS         bool   `json:"is_s"`
-	Brand       string `json:"brand"`
-	ThreadCount int64  `json:"thread_count"`
+	UserID   uint   `gorm:"uniqueIndex:idx_user_brand_thread" json:"user_id"`
+	User     User   `gorm:"foreignKey:UserID" json:"-"`
+	ThreadId string `gorm:"uniqueIndex:idx_user_brand_thread" json:"thread_id"`
+	Brand    string `gorm:"uniqueIndex:idx_user_brand_thread" json:"brand"`
+	// ThreadCount est le total des formats entiers (Skeins + Cards + Spools)
+	ThreadCount int64 `json:"thread_count"`
+	Skeins      int64 `gorm:"default:0" json:"skeins"`
+	// Cards compte le fil enroulé sur carte, Spools sur bobine
+	Cards  int64 `gorm:"default:0" json:"cards"`
+	Spools int64 `gorm:"default:0" json:"spools"`
+	// Restes entamés, en mètres, hors ThreadCount
+	PartialMetres float64 `gorm:"default:0" json:"partial_metres"`
+	// IsCustom marque un coloris absent du catalogue de la marque
+	IsCustom       bool          `json:"is_custom"`
+	CatalogColorID *uint         `json:"catalog_color_id"`
+	CatalogColor   *CatalogColor `gorm:"foreignKey:CatalogColorID" json:"catalog_color,omitempty"`
+	// MinQuantity remplace User.DefaultMinQuantity pour ce fil
+	MinQuantity        *int64     `json:"min_quantity"`
+	LowStock           bool       `gorm:"index" json:"low_stock"`
+	LowStockNotifiedAt *time.Time `json:"low_stock_notified_at"`
+	LocationID         *uint      `gorm:"index" json:"location_id"`
+	Location           *Location  `gorm:"foreignKey:LocationID;constraint:OnDelete:SET NULL" json:"-"`
+	Tags               []Tag      `gorm:"many2many:thread_tags" json:"tags,omitempty"`
+}
+
+// MarshalJSON ajoute les anciens drapeaux is_e/is_c/is_s, déduits des
+// quantités, pour les clients qui ne connaissent pas encore les formats.
+func (t Thread) MarshalJSON() ([]byte, error) {
+	type thread Thread
+	return json.Marshal(struct {
+		thread
+		IsE bool `json:"is_e"`
+		IsC bool `json:"is_c"`
+		IsS bool `json:"is_s"`
+	}{thread(t), t.Skeins > 0, t.Cards > 0, t.Spools > 0})
+}
+
+// SetLegacyCount range count dans le format désigné par les anciens
+// drapeaux : échevettes (is_e), cartes (is_c) puis bobines (is_s), et
+// échevettes si aucun n'est positionné.
+func (t *Thread) SetLegacyCount(count int64, isE, isC, isS bool) {
+	t.Skeins, t.Cards, t.Spools = 0, 0, 0
+	switch {
+	case isE || (!isC && !isS):
+		t.Skeins = count
+	case isC:
+		t.Cards = count
+	default:
+		t.Spools = count
+	}
+	t.ThreadCount = count
+}
+
+// SetTotal ajuste les quantités pour atteindre total : un ajout va au format
+// principal (le premier non vide), un retrait le vide en premier.
+func (t *Thread) SetTotal(total int64) {
+	formats := []*int64{&t.Skeins, &t.Cards, &t.Spools}
+	primary := formats[0]
+	for _, f := range formats {
+		if *f > 0 {
+			primary = f
+			break
+		}
+	}
+
+	diff := total - (t.Skeins + t.Cards + t.Spools)
+	if diff >= 0 {
+		*primary += diff
+	} else {
+		for _, f := range append([]*int64{primary}, formats...) {
+			take := min(*f, -diff)
+			*f -= take
+			diff += take
+		}
+	}
+	t.ThreadCount = t.Skeins + t.Cards + t.Spools
+}
+
+// Tag est une étiquette libre posée sur des fils ("variegated", "SAL Noël"...).
+type Tag struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	UserID    uint      `gorm:"uniqueIndex:idx_user_tag" json:"user_id"`
+	User      User      `gorm:"foreignKey:UserID" json:"-"`
+	Name      string    `gorm:"uniqueIndex:idx_user_tag" json:"name"`
+	Color     string    `json:"color"`
+}
+
+// Location est un emplacement de rangement : boîte, tiroir ou case.
+type Location struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	UserID    uint      `gorm:"index" json:"user_id"`
+	User      User      `gorm:"foreignKey:UserID" json:"-"`
+	ParentID  *uint     `gorm:"index" json:"parent_id"`
+	Parent    *Location `gorm:"foreignKey:ParentID" json:"-"`
+	Name      string    `json:"name"`
+	// Kind vaut "box", "drawer" ou "slot"
+	Kind string `json:"kind"`
+}
+
+type Brand struct {
+	ID   uint   `gorm:"primarykey" json:"id"`
+	Code string `gorm:"uniqueIndex" json:"code"`
+	Name string `json:"name"`
+	// Longueur d'une échevette, en mètres
+	SkeinLength float64 `json:"skein_length"`
+	Strands     int     `json:"strands"`
+}
+
+type CatalogColor struct {
+	ID           uint   `gorm:"primarykey" json:"id"`
+	BrandID      uint   `gorm:"uniqueIndex:idx_brand_color" json:"brand_id"`
+	Brand        *Brand `gorm:"foreignKey:BrandID" json:"brand,omitempty"`
+	Number       string `gorm:"uniqueIndex:idx_brand_color" json:"number"`
+	Name         string `json:"name"`
+	Hex          string `json:"hex"`
+	R            uint8  `json:"r"`
+	G            uint8  `json:"g"`
+	B            uint8  `json:"b"`
+	Discontinued bool   `json:"discontinued"`
+}
+
+// StockMovement est une entrée du journal (append-only) des variations de ThreadCount.
+type StockMovement struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+	UserID    uint      `gorm:"index" json:"user_id"`
+	ThreadID  uint      `gorm:"index" json:"thread"`
+	Thread    Thread    `gorm:"foreignKey:ThreadID" json:"-"`
+	Event     string    `json:"event"`
+	Reason    string    `json:"reason"`
+	Delta     int64     `json:"delta"`
+	Balance   int64     `json:"balance"`
+}
+
+type Project struct {
+	gorm.Model
+	UserID       uint                 `gorm:"index" json:"user_id"`
+	User         User                 `gorm:"foreignKey:UserID" json:"-"`
+	Name         string               `json:"name"`
+	Description  string               `json:"description"`
+	Requirements []ProjectRequirement `gorm:"foreignKey:ProjectID;constraint:OnDelete:CASCADE" json:"requirements"`
+}
+
+// Chart est une grille de points enregistrée, importée d'un fichier OXS ou
+// générée depuis une image. Cells contient, ligne par ligne, l'index du
+// coloris dans Colors, ou -1 pour une case vide.
+type Chart struct {
+	ID        uint         `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	UserID    uint         `gorm:"index" json:"user_id"`
+	User      User         `gorm:"foreignKey:UserID" json:"-"`
+	Title     string       `json:"title"`
+	Source    string       `json:"source"`
+	Width     int          `json:"width"`
+	Height    int          `json:"height"`
+	Cells     []int        `gorm:"serializer:json" json:"-"`
+	Colors    []ChartColor `gorm:"serializer:json" json:"colors"`
+}
+
+type ChartColor struct {
+	Brand    string `json:"brand"`
+	ThreadId string `json:"thread_id"`
+	Name     string `json:"name"`
+	Hex      string `json:"hex"`
+}
+
+func (c Chart) Grid() PatternGrid {
+	return PatternGrid{Width: c.Width, Height: c.Height, Cells: c.Cells}
+}
+
+// ProjectRequirement est un coloris nécessaire à un projet, en échevettes ou en mètres.
+type ProjectRequirement struct {
+	ID        uint    `gorm:"primarykey" json:"id"`
+	ProjectID uint    `gorm:"index" json:"project_id"`
+	Brand     string  `json:"brand"`
+	ThreadId  string  `json:"thread_id"`
+	Quantity  float64 `json:"quantity"`
+	Unit      string  `json:"unit"`
+}
+
+// ShoppingListItem est un coloris à acheter. Un seul article non acheté par
+// coloris : un nouvel ajout incrémente sa quantité.
+type ShoppingListItem struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	UserID    uint      `gorm:"uniqueIndex:idx_shopping_open,where:bought_at IS NULL" json:"user_id"`
+	User      User      `gorm:"foreignKey:UserID" json:"-"`
+	Brand     string    `gorm:"uniqueIndex:idx_shopping_open" json:"brand"`
+	ThreadId  string    `gorm:"uniqueIndex:idx_shopping_open" json:"thread_id"`
+	Quantity  int64     `json:"quantity"`
+	// Source vaut "manual" ou "auto" (fil arrivé à zéro)
+	Source   string     `json:"source"`
+	Note     string     `json:"note"`
+	BoughtAt *time.Time `json:"bought_at"`
+}
+
+// ColorConversion est une équivalence officielle entre deux coloris de marques
+// différentes. Chaque paire est enregistrée dans les deux sens.
+type ColorConversion struct {
+	ID          uint          `gorm:"primarykey" json:"id"`
+	FromColorID uint          `gorm:"uniqueIndex:idx_color_conversion" json:"from_color_id"`
+	FromColor   *CatalogColor `gorm:"foreignKey:FromColorID" json:"-"`
+	ToColorID   uint          `gorm:"uniqueIndex:idx_color_conversion" json:"to_color_id"`
+	ToColor     *CatalogColor `gorm:"foreignKey:ToColorID" json:"to_color,omitempty"`
 }
 
 type PasswordResetToken struct {
@@ -35,6 +242,91 @@ type PasswordResetToken struct {
 	ExpiresAt time.Time `json:"expires_at"`
 }
 
+// EmailVerificationToken confirme l'adresse d'un compte. Seule l'empreinte du
+// jeton envoyé par email est stockée.
+type EmailVerificationToken struct {
+	gorm.Model
+	UserID    uint      `gorm:"index" json:"user_id"`
+	User      User      `gorm:"foreignKey:UserID" json:"-"`
+	TokenHash string    `gorm:"uniqueIndex" json:"-"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type VerifyEmailDto struct {
+	Token string `json:"token"`
+}
+
+// EmailChangeRequest est un changement d'adresse en attente : la nouvelle
+// adresse reçoit le lien de confirmation, l'ancienne celui d'annulation.
+type EmailChangeRequest struct {
+	gorm.Model
+	UserID      uint      `gorm:"index" json:"user_id"`
+	User        User      `gorm:"foreignKey:UserID" json:"-"`
+	NewEmail    string    `json:"new_email"`
+	ConfirmHash string    `gorm:"uniqueIndex" json:"-"`
+	CancelHash  string    `gorm:"uniqueIndex" json:"-"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type ChangeEmailDto struct {
+	NewEmail        string `json:"new_email"`
+	CurrentPassword string `json:"current_password"`
+}
+
+type EmailChangeTokenDto struct {
+	Token string `json:"token"`
+}
+
+// Session est une connexion ouverte par Login ou Register. Le claim jti du
+// JWT la désigne ; une session révoquée ou expirée invalide son jeton.
+type Session struct {
+	ID         uint       `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"-"`
+	UserID     uint       `gorm:"index" json:"-"`
+	User       User       `gorm:"foreignKey:UserID" json:"-"`
+	JTI        string     `gorm:"uniqueIndex" json:"-"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `gorm:"index" json:"expires_at"`
+	RevokedAt  *time.Time `json:"-"`
+	// Current signale la session du jeton utilisé pour la requête
+	Current bool `gorm:"-" json:"current"`
+}
+
+// RefreshToken est un jeton opaque à usage unique dont seul le hash SHA-256
+// est stocké. Les jetons d'une même session forment une famille : chaque
+// rafraîchissement en émet un nouveau et marque l'ancien comme consommé.
+type RefreshToken struct {
+	ID        uint       `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	SessionID uint       `gorm:"index" json:"session_id"`
+	Session   Session    `gorm:"foreignKey:SessionID;constraint:OnDelete:CASCADE" json:"-"`
+	TokenHash string     `gorm:"uniqueIndex" json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RotatedAt *time.Time `json:"rotated_at"`
+}
+
+// TokenPair est renvoyé à la connexion et à chaque rafraîchissement.
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	// Durée de validité du jeton d'accès, en secondes
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+type RefreshTokenDto struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// SessionInfo décrit le client qui ouvre une session.
+type SessionInfo struct {
+	UserAgent string
+	IP        string
+}
+
 type LoginDto struct {
 	Email    string `json:"email" binding:"required"`
 	Password string `json:"password" binding:"required"`
@@ -64,13 +356,381 @@ type ContactDto struct {
 	Message string `json:"message" binding:"required"`
 }
 
+// ThreadDto accepte les quantités par format ou, pour les anciens clients,
+// thread_count accompagné des drapeaux is_e/is_c/is_s.
 type ThreadDto struct {
-	ThreadId    string `json:"thread_id"`
-	IsE         bool   `json:"is_e"`
-	IsC         bool   `json:"is_c"`
-	IsS         bool   `json:"is_s"`
-	Brand       string `json:"brand"`
-	ThreadCount int64  `json:"thread_count"`
+	ThreadId      string   `json:"thread_id"`
+	IsE           bool     `json:"is_e"`
+	IsC           bool     `json:"is_c"`
+	IsS           bool     `json:"is_s"`
+	Brand         string   `json:"brand"`
+	ThreadCount   int64    `json:"thread_count"`
+	Skeins        *int64   `json:"skeins"`
+	Cards         *int64   `json:"cards"`
+	Spools        *int64   `json:"spools"`
+	PartialMetres *float64 `json:"partial_metres"`
+	IsCustom      bool     `json:"is_custom"`
+	MinQuantity   *int64   `json:"min_quantity"`
+	LocationID    *uint    `json:"location_id"`
+}
+
+// HasFormats indique si le DTO porte des quantités par format.
+func (dto ThreadDto) HasFormats() bool {
+	return dto.Skeins != nil || dto.Cards != nil || dto.Spools != nil
+}
+
+func NewThreadFromDto(userID uint, dto ThreadDto) Thread {
+	thread := Thread{
+		UserID:      userID,
+		ThreadId:    dto.ThreadId,
+		Brand:       dto.Brand,
+		IsCustom:    dto.IsCustom,
+		MinQuantity: dto.MinQuantity,
+		LocationID:  dto.LocationID,
+	}
+	if dto.HasFormats() {
+		for dest, v := range map[*int64]*int64{&thread.Skeins: dto.Skeins, &thread.Cards: dto.Cards, &thread.Spools: dto.Spools} {
+			if v != nil {
+				*dest = *v
+			}
+		}
+		thread.ThreadCount = thread.Skeins + thread.Cards + thread.Spools
+	} else {
+		thread.SetLegacyCount(dto.ThreadCount, dto.IsE, dto.IsC, dto.IsS)
+	}
+	if dto.PartialMetres != nil {
+		thread.PartialMetres = *dto.PartialMetres
+	}
+	return thread
+}
+
+// SetQuantities recopie les quantités du fil, anciens champs compris.
+func (dto *ThreadDto) SetQuantities(t Thread) {
+	dto.ThreadCount = t.ThreadCount
+	dto.IsE, dto.IsC, dto.IsS = t.Skeins > 0, t.Cards > 0, t.Spools > 0
+	dto.Skeins, dto.Cards, dto.Spools = &t.Skeins, &t.Cards, &t.Spools
+	dto.PartialMetres = &t.PartialMetres
+}
+
+type ThreadKey struct {
+	Brand    string `json:"brand"`
+	ThreadId string `json:"thread_id"`
+}
+
+// ThreadCursor mémorise le tri qui l'a produit, pour refuser sa réutilisation
+// avec un autre tri.
+type ThreadCursor struct {
+	Sort  string `json:"s,omitempty"`
+	Desc  bool   `json:"d,omitempty"`
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+}
+
+type ThreadListQuery struct {
+	Limit    int
+	Cursor   *ThreadCursor
+	Sort     string
+	Desc     bool
+	Brand    string
+	IsE      *bool
+	IsC      *bool
+	IsS      *bool
+	MinCount *int64
+	MaxCount *int64
+	// LocationID filtre sur un emplacement et ses sous-emplacements
+	LocationID *uint
+	Unlocated  bool
+	// LocationIDs est calculé par le service à partir de LocationID
+	LocationIDs []uint
+	// Tags ne garde que les fils portant toutes ces étiquettes
+	Tags []string
+}
+
+type ThreadPage struct {
+	Items      []Thread `json:"items"`
+	Total      int64    `json:"total"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+}
+
+type StockMovementHistory struct {
+	Movements      []StockMovement `json:"movements"`
+	LastPurchaseAt *time.Time      `json:"last_purchase_at"`
+	UsedPerMonth   float64         `json:"used_per_month"`
+}
+
+type ConversionCandidate struct {
+	Color       CatalogColor `json:"color"`
+	Official    bool         `json:"official"`
+	DeltaE      float64      `json:"delta_e"`
+	Owned       bool         `json:"owned"`
+	ThreadCount int64        `json:"thread_count"`
+}
+
+type ConversionResult struct {
+	From       CatalogColor          `json:"from"`
+	To         Brand                 `json:"to"`
+	Candidates []ConversionCandidate `json:"candidates"`
+}
+
+type SimilarThread struct {
+	Thread Thread  `json:"thread"`
+	DeltaE float64 `json:"delta_e"`
+}
+
+type ImportRowResult struct {
+	Line   int        `json:"line"`
+	Status string     `json:"status"`
+	Error  string     `json:"error,omitempty"`
+	Thread *ThreadDto `json:"thread,omitempty"`
+}
+
+type ImportReport struct {
+	DryRun   bool              `json:"dry_run"`
+	Created  int               `json:"created"`
+	Updated  int               `json:"updated"`
+	Restored int               `json:"restored"`
+	Failed   int               `json:"failed"`
+	Rows     []ImportRowResult `json:"rows"`
+}
+
+type BatchOperation struct {
+	Op string `json:"op"`
+	// ID identifie le fil pour update/delete ; à défaut (brand, thread_id) de Thread
+	ID     uint      `json:"id,omitempty"`
+	Thread ThreadDto `json:"thread"`
+}
+
+type BatchRequest struct {
+	// Atomic (true par défaut) annule tout le lot au premier échec
+	Atomic     *bool            `json:"atomic"`
+	Reason     string           `json:"reason"`
+	Operations []BatchOperation `json:"operations"`
+}
+
+type BatchOperationResult struct {
+	Index  int     `json:"index"`
+	Op     string  `json:"op"`
+	Status string  `json:"status"`
+	Error  string  `json:"error,omitempty"`
+	Thread *Thread `json:"thread,omitempty"`
+}
+
+type BatchResult struct {
+	Atomic    bool                   `json:"atomic"`
+	Committed bool                   `json:"committed"`
+	Results   []BatchOperationResult `json:"results"`
+}
+
+type LowStockDefaultDto struct {
+	DefaultMinQuantity int64 `json:"default_min_quantity"`
+}
+
+type ProjectRequirementDto struct {
+	Brand    string  `json:"brand"`
+	ThreadId string  `json:"thread_id"`
+	Quantity float64 `json:"quantity"`
+	Unit     string  `json:"unit"`
+}
+
+type ProjectDto struct {
+	Name         string                  `json:"name"`
+	Description  string                  `json:"description"`
+	Requirements []ProjectRequirementDto `json:"requirements"`
+}
+
+type ShortfallItem struct {
+	Brand          string  `json:"brand"`
+	ThreadId       string  `json:"thread_id"`
+	RequiredSkeins float64 `json:"required_skeins"`
+	Owned          int64   `json:"owned"`
+	Missing        int64   `json:"missing"`
+}
+
+type ProjectShortfall struct {
+	ProjectID    uint            `json:"project_id"`
+	Items        []ShortfallItem `json:"items"`
+	TotalMissing int64           `json:"total_missing"`
+}
+
+// PatternImportOptions décrit la toile et le nombre de brins par défaut d'une grille.
+type PatternImportOptions struct {
+	FabricCount int
+	Strands     int
+	Brand       string
+}
+
+type PatternThread struct {
+	Brand     string `json:"brand"`
+	ThreadId  string `json:"thread_id"`
+	Name      string `json:"name"`
+	Hex       string `json:"hex"`
+	InCatalog bool   `json:"in_catalog"`
+	// Équivalents points de croix complets
+	Stitches         float64 `json:"stitches"`
+	BackstitchLength float64 `json:"backstitch_length"`
+	Knots            int     `json:"knots"`
+	EstimatedMetres  float64 `json:"estimated_metres"`
+	EstimatedSkeins  float64 `json:"estimated_skeins"`
+	Owned            int64   `json:"owned"`
+	Missing          int64   `json:"missing"`
+}
+
+type PatternImport struct {
+	// ChartID identifie la grille enregistrée, 0 si elle n'a pas pu l'être
+	ChartID      uint            `json:"chart_id,omitempty"`
+	Title        string          `json:"title"`
+	Width        int             `json:"width"`
+	Height       int             `json:"height"`
+	FabricCount  int             `json:"fabric_count"`
+	Threads      []PatternThread `json:"threads"`
+	TotalMissing int64           `json:"total_missing"`
+	Warnings     []string        `json:"warnings,omitempty"`
+}
+
+type ImagePatternOptions struct {
+	Title string
+	// Taille de la grille en points ; une seule dimension suffit
+	Width       int
+	Height      int
+	MaxColors   int
+	Dither      bool
+	Brand       string
+	FabricCount int
+	Strands     int
+}
+
+// PatternGeneration est une grille générée depuis une image : chaque case de
+// Grid est l'index du fil dans Threads, ou -1 pour une case vide.
+type PatternGeneration struct {
+	PatternImport
+	Grid [][]int `json:"grid"`
+}
+
+// ChartDetail est une grille enregistrée avec ses cases, ligne par ligne.
+type ChartDetail struct {
+	Chart
+	Grid [][]int `json:"grid"`
+}
+
+// ChartKeyEntry est une ligne de la légende imprimée.
+type ChartKeyEntry struct {
+	Symbol   string
+	Color    ChartColor
+	Stitches int
+	Owned    int64
+}
+
+type ChartRenderOptions struct {
+	Format string
+	Mode   string
+	// Page à produire pour SVG et PNG, à partir de 1 ; le PDF contient toutes les pages
+	Page int
+}
+
+type RenderedChart struct {
+	ContentType string
+	Filename    string
+	Pages       int
+	Data        []byte
+}
+
+type ShoppingListItemDto struct {
+	Brand    string `json:"brand"`
+	ThreadId string `json:"thread_id"`
+	Quantity int64  `json:"quantity"`
+	Note     string `json:"note"`
+}
+
+type ShoppingListReceiveItem struct {
+	ID uint `json:"id"`
+	// Quantité reçue, celle de l'article si absente
+	Quantity int64 `json:"quantity"`
+}
+
+type ShoppingListReceiveDto struct {
+	Items []ShoppingListReceiveItem `json:"items"`
+}
+
+type ShoppingListReceipt struct {
+	Items   []ShoppingListItem `json:"items"`
+	Threads []Thread           `json:"threads"`
+}
+
+type LocationDto struct {
+	Name     string `json:"name"`
+	Kind     string `json:"kind"`
+	ParentID *uint  `json:"parent_id"`
+}
+
+type LocationTree struct {
+	Location
+	Children []*LocationTree `json:"children"`
+}
+
+type ThreadLocation struct {
+	Thread Thread     `json:"thread"`
+	Path   []Location `json:"path"`
+	// Chemin lisible, ex. "Boîte A > Tiroir 2 > Case 5"
+	Label string `json:"label"`
+}
+
+// MoveThreadsDto déplace soit les fils listés, soit tout le contenu d'un
+// emplacement. Une LocationID nulle retire les fils de leur emplacement.
+type MoveThreadsDto struct {
+	ThreadIDs      []uint `json:"thread_ids"`
+	FromLocationID *uint  `json:"from_location_id"`
+	LocationID     *uint  `json:"location_id"`
+}
+
+type MoveThreadsResult struct {
+	Moved int64 `json:"moved"`
+}
+
+type TagDto struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+type ThreadTagsDto struct {
+	ThreadIDs []uint `json:"thread_ids"`
+	TagIDs    []uint `json:"tag_ids"`
+}
+
+// SkeinCalculationDto décrit l'ouvrage : un nombre de points, ou une grille
+// width x height remplie à coverage (0 à 1), ou une longueur de points
+// arrière exprimée en cases.
+type SkeinCalculationDto struct {
+	Stitches         *float64 `json:"stitches"`
+	Width            int      `json:"width"`
+	Height           int      `json:"height"`
+	Coverage         float64  `json:"coverage"`
+	FabricCount      float64  `json:"fabric_count"`
+	OverTwo          bool     `json:"over_two"`
+	Strands          int      `json:"strands"`
+	StitchType       string   `json:"stitch_type"`
+	BackstitchLength float64  `json:"backstitch_length"`
+	// WasteFactor est une marge relative : 0.2 pour 20 %
+	WasteFactor *float64 `json:"waste_factor"`
+	Brands      []string `json:"brands"`
+}
+
+type BrandSkeinEstimate struct {
+	Brand        string  `json:"brand"`
+	SkeinLength  float64 `json:"skein_length"`
+	SkeinStrands int     `json:"skein_strands"`
+	Skeins       float64 `json:"skeins"`
+	ToBuy        int64   `json:"to_buy"`
+}
+
+type SkeinCalculation struct {
+	StitchType      string  `json:"stitch_type"`
+	Stitches        float64 `json:"stitches"`
+	StitchesPerInch float64 `json:"stitches_per_inch"`
+	Strands         int     `json:"strands"`
+	WasteFactor     float64 `json:"waste_factor"`
+	// Longueur de brin simple nécessaire, en mètres
+	Metres float64              `json:"metres"`
+	Brands []BrandSkeinEstimate `json:"brands"`
 }
 
 type PasswordDto struct {
@@ -85,15 +745,119 @@ type UserRepository interface {
 	GetByEmail(ctx context.Context, email string) (*User, error)
 	Create(ctx context.Context, user *User) error
 	Update(ctx context.Context, user *User) error
+	UpdateFields(ctx context.Context, id uint, fields map[string]any) error
+	// UpdateEmail renvoie gorm.ErrDuplicatedKey si l'adresse est déjà prise
+	UpdateEmail(ctx context.Context, id uint, email string, verifiedAt time.Time) error
 }
 
 type ThreadRepository interface {
 	GetByID(ctx context.Context, id uint) (*Thread, error)
 	GetByUserID(ctx context.Context, userID uint) ([]Thread, error)
-	Create(ctx context.Context, thread *Thread) error
+	List(ctx context.Context, userID uint, query ThreadListQuery) ([]Thread, int64, error)
+	// StreamByUserID parcourt les fils de l'utilisateur par lots, sans tout charger en mémoire.
+	StreamByUserID(ctx context.Context, userID uint, batchSize int, fn func([]Thread) error) error
+	GetByKeys(ctx context.Context, userID uint, keys []ThreadKey) ([]Thread, error)
+	// Create renvoie true si le fil existait en corbeille et a été restauré.
+	Create(ctx context.Context, thread *Thread) (bool, error)
 	Update(ctx context.Context, thread *Thread) error
+	Patch(ctx context.Context, userID uint, id uint, fields map[string]any) error
+	Delete(ctx context.Context, userID uint, id uint) error
+	DeleteMultiple(ctx context.Context, userID uint, keys []ThreadKey) error
+	GetLowStock(ctx context.Context, userID uint) ([]Thread, error)
+	GetTrash(ctx context.Context, userID uint) ([]Thread, error)
+	GetTrashedByID(ctx context.Context, userID uint, id uint) (*Thread, error)
+	Restore(ctx context.Context, userID uint, id uint) error
+	GetTrashedBefore(ctx context.Context, cutoff time.Time) ([]uint, error)
+	Purge(ctx context.Context, ids []uint) error
+	GetByIDs(ctx context.Context, userID uint, ids []uint) ([]Thread, error)
+	SetLocation(ctx context.Context, userID uint, ids []uint, locationID *uint) (int64, error)
+	MoveLocationContents(ctx context.Context, userID uint, from uint, locationID *uint) (int64, error)
+}
+
+type StockMovementRepository interface {
+	Create(ctx context.Context, movement *StockMovement) error
+	GetByThreadID(ctx context.Context, userID uint, threadID uint) ([]StockMovement, error)
+	DeleteByThreadIDs(ctx context.Context, threadIDs []uint) error
+}
+
+type ProjectRepository interface {
+	GetByUserID(ctx context.Context, userID uint) ([]Project, error)
+	GetByID(ctx context.Context, userID uint, id uint) (*Project, error)
+	Create(ctx context.Context, project *Project) error
+	Update(ctx context.Context, project *Project) error
+	Delete(ctx context.Context, userID uint, id uint) error
+}
+
+type ChartRepository interface {
+	// GetByUserID ne charge pas les cases des grilles.
+	GetByUserID(ctx context.Context, userID uint) ([]Chart, error)
+	GetByID(ctx context.Context, userID uint, id uint) (*Chart, error)
+	Create(ctx context.Context, chart *Chart) error
+	Delete(ctx context.Context, userID uint, id uint) error
+}
+
+type TagRepository interface {
+	GetByUserID(ctx context.Context, userID uint) ([]Tag, error)
+	GetByIDs(ctx context.Context, userID uint, ids []uint) ([]Tag, error)
+	Create(ctx context.Context, tag *Tag) error
+	Update(ctx context.Context, tag *Tag) error
+	// Delete retire aussi l'étiquette de tous les fils.
 	Delete(ctx context.Context, userID uint, id uint) error
-	DeleteMultiple(ctx context.Context, userID uint, ids []string) error
+	Attach(ctx context.Context, threadIDs []uint, tagIDs []uint) error
+	Detach(ctx context.Context, threadIDs []uint, tagIDs []uint) error
+}
+
+type LocationRepository interface {
+	GetByUserID(ctx context.Context, userID uint) ([]Location, error)
+	GetByID(ctx context.Context, userID uint, id uint) (*Location, error)
+	Create(ctx context.Context, location *Location) error
+	Update(ctx context.Context, location *Location) error
+	Delete(ctx context.Context, userID uint, id uint) error
+}
+
+type ShoppingListRepository interface {
+	GetByUserID(ctx context.Context, userID uint, includeBought bool) ([]ShoppingListItem, error)
+	GetOpenByIDs(ctx context.Context, userID uint, ids []uint) ([]ShoppingListItem, error)
+	GetOpenByKey(ctx context.Context, userID uint, key ThreadKey) (*ShoppingListItem, error)
+	Create(ctx context.Context, item *ShoppingListItem) error
+	Update(ctx context.Context, item *ShoppingListItem) error
+	MarkBought(ctx context.Context, userID uint, ids []uint, at time.Time) error
+	Delete(ctx context.Context, userID uint, id uint) error
+	DeleteOpenAuto(ctx context.Context, userID uint, key ThreadKey) error
+	// DeleteOpenAutoByThreadIDs vise aussi les fils en corbeille
+	DeleteOpenAutoByThreadIDs(ctx context.Context, threadIDs []uint) error
+}
+
+type CatalogRepository interface {
+	UpsertBrand(ctx context.Context, brand *Brand) error
+	UpsertColors(ctx context.Context, colors []CatalogColor) error
+	GetBrands(ctx context.Context) ([]Brand, error)
+	GetBrandByCode(ctx context.Context, code string) (*Brand, error)
+	GetColors(ctx context.Context, brandID uint, search string, includeDiscontinued bool) ([]CatalogColor, error)
+	GetColor(ctx context.Context, brandID uint, number string) (*CatalogColor, error)
+	GetColorByID(ctx context.Context, id uint) (*CatalogColor, error)
+	UpsertConversions(ctx context.Context, conversions []ColorConversion) error
+	GetConversions(ctx context.Context, fromColorID uint, toBrandID uint) ([]CatalogColor, error)
+}
+
+type SessionRepository interface {
+	Create(ctx context.Context, session *Session) error
+	GetByID(ctx context.Context, id uint) (*Session, error)
+	GetByJTI(ctx context.Context, jti string) (*Session, error)
+	GetActiveByUserID(ctx context.Context, userID uint, now time.Time) ([]Session, error)
+	Touch(ctx context.Context, id uint, at time.Time) error
+	// Renew remplace le jti de la session et prolonge son expiration.
+	Renew(ctx context.Context, id uint, jti string, expiresAt time.Time, at time.Time) error
+	// Revoke renvoie gorm.ErrRecordNotFound si la session n'est pas active.
+	Revoke(ctx context.Context, userID uint, id uint, at time.Time) error
+	RevokeOthers(ctx context.Context, userID uint, keepID uint, at time.Time) (int64, error)
+}
+
+type RefreshTokenRepository interface {
+	Create(ctx context.Context, token *RefreshToken) error
+	GetByHash(ctx context.Context, hash string) (*RefreshToken, error)
+	// MarkRotated renvoie false si le jeton avait déjà été consommé.
+	MarkRotated(ctx context.Context, id uint, at time.Time) (bool, error)
 }
 
 type PasswordResetTokenRepository interface {
@@ -101,3 +865,17 @@ type PasswordResetTokenRepository interface {
 	GetByToken(ctx context.Context, token string) (*PasswordResetToken, error)
 	DeleteByUserID(ctx context.Context, userID uint) error
 }
+
+type EmailChangeRepository interface {
+	Create(ctx context.Context, request *EmailChangeRequest) error
+	GetByConfirmHash(ctx context.Context, hash string) (*EmailChangeRequest, error)
+	GetByCancelHash(ctx context.Context, hash string) (*EmailChangeRequest, error)
+	DeleteByUserID(ctx context.Context, userID uint) error
+}
+
+type EmailVerificationRepository interface {
+	Create(ctx context.Context, token *EmailVerificationToken) error
+	GetByHash(ctx context.Context, hash string) (*EmailVerificationToken, error)
+	GetLatestByUserID(ctx context.Context, userID uint) (*EmailVerificationToken, error)
+	DeleteByUserID(ctx context.Context, userID uint) error
+}
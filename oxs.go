@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+var ErrInvalidOXS = errors.New("invalid OXS file")
+
+// maxOXSWarnings limite le nombre d'anomalies remontées pour un fichier.
+const maxOXSWarnings = 50
+
+// OXSChart est le contenu utile d'un fichier Open Cross Stitch (.oxs).
+type OXSChart struct {
+	Title    string            `json:"title"`
+	Width    int               `json:"width"`
+	Height   int               `json:"height"`
+	Palette  []OXSPaletteEntry `json:"palette"`
+	Warnings []string          `json:"warnings,omitempty"`
+	// Stitches positionne les points complets et partiels sur la grille
+	Stitches []OXSStitch `json:"-"`
+}
+
+// OXSStitch est une case de la grille brodée avec le coloris Index de la légende.
+type OXSStitch struct {
+	X     int
+	Y     int
+	Index int
+}
+
+// OXSPaletteEntry est un coloris de la légende et le nombre de points qui l'utilisent.
+type OXSPaletteEntry struct {
+	Index   int    `json:"index"`
+	Brand   string `json:"brand"`
+	Number  string `json:"number"`
+	Name    string `json:"name"`
+	Color   string `json:"color"`
+	Strands int    `json:"strands"`
+	// Brins utilisés pour le point arrière, 0 si non précisé
+	BackstitchStrands int `json:"backstitch_strands"`
+	FullStitches      int `json:"full_stitches"`
+	// Demi-points et quarts de point, chacun compté comme une moitié de croix
+	PartStitches int `json:"part_stitches"`
+	// Longueur cumulée des points arrière, en nombre de points de la grille
+	BackstitchLength float64 `json:"backstitch_length"`
+	Knots            int     `json:"knots"`
+}
+
+// Stitches renvoie le nombre d'équivalents points de croix complets.
+func (e OXSPaletteEntry) Stitches() float64 {
+	return float64(e.FullStitches) + float64(e.PartStitches)/2
+}
+
+type oxsParser struct {
+	chart    *OXSChart
+	entries  map[int]*OXSPaletteEntry
+	counts   map[int]*OXSPaletteEntry
+	warnings int
+}
+
+// ParseOXS lit un fichier OXS. Les points mal formés ou qui référencent un
+// coloris absent de la légende sont ignorés et signalés dans Warnings ; seul
+// un XML illisible ou un document qui n'est pas un OXS provoque une erreur.
+func ParseOXS(r io.Reader) (*OXSChart, error) {
+	p := &oxsParser{
+		chart:   &OXSChart{},
+		entries: map[int]*OXSPaletteEntry{},
+		counts:  map[int]*OXSPaletteEntry{},
+	}
+
+	decoder := xml.NewDecoder(r)
+	// Certains logiciels déclarent un encodage autre qu'UTF-8 (souvent ISO-8859-1)
+	decoder.CharsetReader = oxsCharsetReader
+
+	root := false
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidOXS, err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if !root {
+			if start.Name.Local != "chart" {
+				return nil, fmt.Errorf("%w: root element is <%s>, expected <chart>", ErrInvalidOXS, start.Name.Local)
+			}
+			root = true
+			continue
+		}
+		p.element(start)
+	}
+	if !root {
+		return nil, fmt.Errorf("%w: empty document", ErrInvalidOXS)
+	}
+
+	return p.finish()
+}
+
+// oxsCharsetReader décode les encodages occidentaux sur un octet ; les autres
+// déclarations sont lues telles quelles, comme de l'UTF-8.
+func oxsCharsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "iso8859-1", "latin1", "l1":
+		return charmap.ISO8859_1.NewDecoder().Reader(input), nil
+	case "windows-1252", "cp1252":
+		return charmap.Windows1252.NewDecoder().Reader(input), nil
+	case "iso-8859-15", "latin9":
+		return charmap.ISO8859_15.NewDecoder().Reader(input), nil
+	}
+	return input, nil
+}
+
+func (p *oxsParser) warn(format string, args ...any) {
+	p.warnings++
+	if p.warnings <= maxOXSWarnings {
+		p.chart.Warnings = append(p.chart.Warnings, fmt.Sprintf(format, args...))
+	}
+}
+
+func (p *oxsParser) element(e xml.StartElement) {
+	attrs := make(map[string]string, len(e.Attr))
+	for _, a := range e.Attr {
+		attrs[strings.ToLower(a.Name.Local)] = strings.TrimSpace(a.Value)
+	}
+
+	switch e.Name.Local {
+	case "properties":
+		p.chart.Title = attrs["charttitle"]
+		p.chart.Width, _ = strconv.Atoi(attrs["chartwidth"])
+		p.chart.Height, _ = strconv.Atoi(attrs["chartheight"])
+	case "palette_item":
+		p.paletteItem(attrs)
+	case "stitch":
+		if entry := p.stitchColor(e.Name.Local, attrs["palindex"]); entry != nil {
+			entry.FullStitches++
+			p.place(attrs, entry.Index)
+		}
+	case "partstitch":
+		// Un point partiel peut porter deux coloris, un par moitié de case
+		placed := false
+		for _, key := range []string{"palindex1", "palindex2"} {
+			if v := attrs[key]; v != "" && v != "0" {
+				if entry := p.stitchColor(e.Name.Local, v); entry != nil {
+					entry.PartStitches++
+					// Seul le premier coloris est reporté sur la grille
+					if !placed {
+						p.place(attrs, entry.Index)
+						placed = true
+					}
+				}
+			}
+		}
+	case "backstitch":
+		entry := p.stitchColor(e.Name.Local, attrs["palindex"])
+		if entry == nil {
+			return
+		}
+		var coords [4]float64
+		for i, key := range []string{"x1", "y1", "x2", "y2"} {
+			v, err := strconv.ParseFloat(attrs[key], 64)
+			if err != nil {
+				p.warn("backstitch: invalid %s %q", key, attrs[key])
+				return
+			}
+			coords[i] = v
+		}
+		entry.BackstitchLength += math.Hypot(coords[2]-coords[0], coords[3]-coords[1])
+	case "object":
+		if strings.Contains(strings.ToLower(attrs["objecttype"]), "knot") {
+			if entry := p.stitchColor("knot", attrs["palindex"]); entry != nil {
+				entry.Knots++
+			}
+		}
+	}
+}
+
+// place reporte un point sur la grille ; les coordonnées invalides sont ignorées
+// sans avertissement, le décompte du coloris restant juste.
+func (p *oxsParser) place(attrs map[string]string, index int) {
+	x, errX := strconv.Atoi(attrs["x"])
+	y, errY := strconv.Atoi(attrs["y"])
+	if errX != nil || errY != nil || x < 0 || y < 0 {
+		return
+	}
+	p.chart.Stitches = append(p.chart.Stitches, OXSStitch{X: x, Y: y, Index: index})
+}
+
+func (p *oxsParser) paletteItem(attrs map[string]string) {
+	index, err := strconv.Atoi(attrs["index"])
+	if err != nil {
+		p.warn("palette_item: invalid index %q", attrs["index"])
+		return
+	}
+	// L'entrée 0 représente la toile
+	if index == 0 || strings.EqualFold(attrs["number"], "cloth") {
+		return
+	}
+	if _, ok := p.entries[index]; ok {
+		p.warn("palette_item: duplicate index %d", index)
+		return
+	}
+
+	brand, number := splitOXSNumber(attrs["number"])
+	if number == "" {
+		p.warn("palette_item %d: missing number", index)
+		return
+	}
+	entry := p.counts[index]
+	if entry == nil {
+		entry = &OXSPaletteEntry{}
+	}
+	entry.Index = index
+	entry.Brand = brand
+	entry.Number = number
+	entry.Name = attrs["name"]
+	entry.Color = attrs["color"]
+	entry.Strands, _ = strconv.Atoi(attrs["strands"])
+	entry.BackstitchStrands, _ = strconv.Atoi(attrs["bsstrands"])
+	p.entries[index] = entry
+}
+
+// stitchColor renvoie le compteur du coloris référencé par un point. La
+// légende précède normalement les points, mais on tolère l'ordre inverse.
+func (p *oxsParser) stitchColor(kind, value string) *OXSPaletteEntry {
+	index, err := strconv.Atoi(value)
+	if err != nil || index < 0 {
+		p.warn("%s: invalid palindex %q", kind, value)
+		return nil
+	}
+	if index == 0 {
+		return nil
+	}
+	if entry, ok := p.entries[index]; ok {
+		return entry
+	}
+	entry, ok := p.counts[index]
+	if !ok {
+		entry = &OXSPaletteEntry{Index: index}
+		p.counts[index] = entry
+	}
+	return entry
+}
+
+func (p *oxsParser) finish() (*OXSChart, error) {
+	for index, entry := range p.counts {
+		if _, ok := p.entries[index]; !ok && entry.Stitches()+entry.BackstitchLength+float64(entry.Knots) > 0 {
+			p.warn("palette index %d is used by stitches but missing from the palette", index)
+		}
+	}
+	if len(p.entries) == 0 {
+		return nil, fmt.Errorf("%w: no palette entries", ErrInvalidOXS)
+	}
+	if p.warnings > maxOXSWarnings {
+		p.chart.Warnings = append(p.chart.Warnings, fmt.Sprintf("%d more warnings omitted", p.warnings-maxOXSWarnings))
+	}
+
+	p.chart.Palette = make([]OXSPaletteEntry, 0, len(p.entries))
+	for _, entry := range p.entries {
+		p.chart.Palette = append(p.chart.Palette, *entry)
+	}
+	sort.Slice(p.chart.Palette, func(i, j int) bool { return p.chart.Palette[i].Index < p.chart.Palette[j].Index })
+	return p.chart, nil
+}
+
+// splitOXSNumber sépare la marque du numéro : "DMC 310" donne ("DMC", "310").
+// Un numéro sans préfixe de marque est renvoyé tel quel avec une marque vide.
+func splitOXSNumber(value string) (string, string) {
+	fields := strings.Fields(value)
+	switch len(fields) {
+	case 0:
+		return "", ""
+	case 1:
+		return "", fields[0]
+	}
+	return fields[0], strings.Join(fields[1:], " ")
+}
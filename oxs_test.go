@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// oxsDoc assemble un fichier OXS minimal autour de la légende et des points.
+func oxsDoc(palette, stitches string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<chart>
+<format comments01="test"/>
+<properties charttitle="Test" chartwidth="10" chartheight="8"/>
+<palette>
+<palette_item index="0" number="cloth" name="Aida" color="FFFFFF"/>
+` + palette + `
+</palette>
+<fullstitches>
+` + stitches + `
+</fullstitches>
+</chart>`
+}
+
+const oxsTwoColors = `<palette_item index="1" number="DMC 310" name="Black" color="000000" strands="2" bsstrands="1"/>
+<palette_item index="2" number="DMC 321" name="Red" color="C72B3B" strands="2"/>`
+
+func TestParseOXSErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"empty document", ""},
+		{"declaration only", `<?xml version="1.0" encoding="UTF-8"?>`},
+		{"wrong root element", `<?xml version="1.0"?><pattern><palette/></pattern>`},
+		{"html", `<html><body>not a chart</body></html>`},
+		{"broken xml", `<chart><palette><palette_item index="1"</chart>`},
+		{"no palette entries", oxsDoc("", `<stitch x="0" y="0" palindex="1"/>`)},
+		{"only the cloth", `<chart><palette><palette_item index="0" number="cloth"/></palette></chart>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chart, err := ParseOXS(strings.NewReader(tt.doc))
+			if !errors.Is(err, ErrInvalidOXS) {
+				t.Fatalf("ParseOXS() error = %v, want ErrInvalidOXS", err)
+			}
+			if chart != nil {
+				t.Errorf("ParseOXS() chart = %+v, want nil", chart)
+			}
+		})
+	}
+}
+
+func TestParseOXS(t *testing.T) {
+	tests := []struct {
+		name     string
+		doc      string
+		palette  []OXSPaletteEntry
+		stitches []OXSStitch
+		warnings []string
+	}{
+		{
+			name: "full stitches and knots",
+			doc: oxsDoc(oxsTwoColors, `<stitch x="0" y="0" palindex="1"/>
+<stitch x="1" y="0" palindex="1"/>
+<stitch x="2" y="3" palindex="2"/>
+<object objecttype="knot" x1="1" y1="1" palindex="2"/>`),
+			palette: []OXSPaletteEntry{
+				{Index: 1, Brand: "DMC", Number: "310", Name: "Black", Color: "000000", Strands: 2, BackstitchStrands: 1, FullStitches: 2},
+				{Index: 2, Brand: "DMC", Number: "321", Name: "Red", Color: "C72B3B", Strands: 2, FullStitches: 1, Knots: 1},
+			},
+			stitches: []OXSStitch{{0, 0, 1}, {1, 0, 1}, {2, 3, 2}},
+		},
+		{
+			name: "partstitch with two colours",
+			doc: oxsDoc(oxsTwoColors, `<partstitch x="4" y="5" palindex1="1" palindex2="2" direction="1"/>
+<partstitch x="5" y="5" palindex1="0" palindex2="2" direction="2"/>`),
+			palette: []OXSPaletteEntry{
+				{Index: 1, Brand: "DMC", Number: "310", Name: "Black", Color: "000000", Strands: 2, BackstitchStrands: 1, PartStitches: 1},
+				{Index: 2, Brand: "DMC", Number: "321", Name: "Red", Color: "C72B3B", Strands: 2, PartStitches: 2},
+			},
+			// Le premier coloris non nul occupe la case sur la grille
+			stitches: []OXSStitch{{4, 5, 1}, {5, 5, 2}},
+		},
+		{
+			name: "backstitch length",
+			doc: oxsDoc(oxsTwoColors, `<backstitch x1="0" y1="0" x2="3" y2="4" palindex="1"/>
+<backstitch x1="1" y1="1" x2="1" y2="3.5" palindex="1"/>`),
+			palette: []OXSPaletteEntry{
+				{Index: 1, Brand: "DMC", Number: "310", Name: "Black", Color: "000000", Strands: 2, BackstitchStrands: 1, BackstitchLength: 7.5},
+				{Index: 2, Brand: "DMC", Number: "321", Name: "Red", Color: "C72B3B", Strands: 2},
+			},
+		},
+		{
+			name: "bad backstitch coordinate",
+			doc: oxsDoc(oxsTwoColors, `<backstitch x1="0" y1="0" x2="abc" y2="2" palindex="1"/>
+<backstitch x1="0" y1="0" x2="0" y2="2" palindex="1"/>`),
+			palette: []OXSPaletteEntry{
+				{Index: 1, Brand: "DMC", Number: "310", Name: "Black", Color: "000000", Strands: 2, BackstitchStrands: 1, BackstitchLength: 2},
+				{Index: 2, Brand: "DMC", Number: "321", Name: "Red", Color: "C72B3B", Strands: 2},
+			},
+			warnings: []string{`backstitch: invalid x2 "abc"`},
+		},
+		{
+			name: "duplicate palette index",
+			doc: oxsDoc(oxsTwoColors+`
+<palette_item index="2" number="DMC 666" name="Bright Red" color="E31D42"/>`, `<stitch x="0" y="0" palindex="2"/>`),
+			palette: []OXSPaletteEntry{
+				{Index: 1, Brand: "DMC", Number: "310", Name: "Black", Color: "000000", Strands: 2, BackstitchStrands: 1},
+				{Index: 2, Brand: "DMC", Number: "321", Name: "Red", Color: "C72B3B", Strands: 2, FullStitches: 1},
+			},
+			stitches: []OXSStitch{{0, 0, 2}},
+			warnings: []string{"palette_item: duplicate index 2"},
+		},
+		{
+			name: "missing palette index",
+			doc: oxsDoc(oxsTwoColors, `<stitch x="0" y="0" palindex="1"/>
+<stitch x="1" y="0" palindex="7"/>`),
+			palette: []OXSPaletteEntry{
+				{Index: 1, Brand: "DMC", Number: "310", Name: "Black", Color: "000000", Strands: 2, BackstitchStrands: 1, FullStitches: 1},
+				{Index: 2, Brand: "DMC", Number: "321", Name: "Red", Color: "C72B3B", Strands: 2},
+			},
+			// La case reste sur la grille même si la légende ne connaît pas le coloris
+			stitches: []OXSStitch{{0, 0, 1}, {1, 0, 7}},
+			warnings: []string{"palette index 7 is used by stitches but missing from the palette"},
+		},
+		{
+			name: "palette after the stitches",
+			doc: `<chart><fullstitches><stitch x="0" y="0" palindex="1"/></fullstitches>
+<palette><palette_item index="1" number="310" name="Black"/></palette></chart>`,
+			palette: []OXSPaletteEntry{
+				{Index: 1, Number: "310", Name: "Black", FullStitches: 1},
+			},
+			stitches: []OXSStitch{{0, 0, 1}},
+		},
+		{
+			name: "invalid palette entries",
+			doc: oxsDoc(oxsTwoColors+`
+<palette_item index="x" number="DMC 666"/>
+<palette_item index="3" number=""/>`, `<stitch x="0" y="0" palindex="-1"/>
+<stitch x="-1" y="2" palindex="2"/>`),
+			palette: []OXSPaletteEntry{
+				{Index: 1, Brand: "DMC", Number: "310", Name: "Black", Color: "000000", Strands: 2, BackstitchStrands: 1},
+				{Index: 2, Brand: "DMC", Number: "321", Name: "Red", Color: "C72B3B", Strands: 2, FullStitches: 1},
+			},
+			// Les coordonnées négatives sont écartées de la grille sans avertissement
+			warnings: []string{
+				`palette_item: invalid index "x"`,
+				"palette_item 3: missing number",
+				`stitch: invalid palindex "-1"`,
+			},
+		},
+		{
+			name: "ISO-8859-1 declaration",
+			doc: "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?>\n" +
+				"<chart><properties charttitle=\"C\xe9dille\" chartwidth=\"2\" chartheight=\"2\"/>" +
+				"<palette><palette_item index=\"1\" number=\"DMC 321\" name=\"Rouge \xe9carlate\" color=\"C72B3B\"/></palette>" +
+				"<fullstitches><stitch x=\"1\" y=\"1\" palindex=\"1\"/></fullstitches></chart>",
+			palette: []OXSPaletteEntry{
+				{Index: 1, Brand: "DMC", Number: "321", Name: "Rouge écarlate", Color: "C72B3B", FullStitches: 1},
+			},
+			stitches: []OXSStitch{{1, 1, 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chart, err := ParseOXS(strings.NewReader(tt.doc))
+			if err != nil {
+				t.Fatalf("ParseOXS() error = %v", err)
+			}
+			if !reflect.DeepEqual(chart.Palette, tt.palette) {
+				t.Errorf("Palette = %+v, want %+v", chart.Palette, tt.palette)
+			}
+			if len(chart.Stitches) != len(tt.stitches) || (len(tt.stitches) > 0 && !reflect.DeepEqual(chart.Stitches, tt.stitches)) {
+				t.Errorf("Stitches = %+v, want %+v", chart.Stitches, tt.stitches)
+			}
+			if len(chart.Warnings) != len(tt.warnings) || (len(tt.warnings) > 0 && !reflect.DeepEqual(chart.Warnings, tt.warnings)) {
+				t.Errorf("Warnings = %q, want %q", chart.Warnings, tt.warnings)
+			}
+		})
+	}
+}
+
+func TestParseOXSProperties(t *testing.T) {
+	tests := []struct {
+		name          string
+		doc           string
+		title         string
+		width, height int
+	}{
+		{"utf-8", oxsDoc(oxsTwoColors, ""), "Test", 10, 8},
+		{"iso-8859-1", "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><chart><properties charttitle=\"C\xe9dille\" chartwidth=\"30\" chartheight=\"20\"/>" +
+			"<palette><palette_item index=\"1\" number=\"310\"/></palette></chart>", "Cédille", 30, 20},
+		{"windows-1252", "<?xml version=\"1.0\" encoding=\"windows-1252\"?><chart><properties charttitle=\"C\x9cur\" chartwidth=\"5\" chartheight=\"5\"/>" +
+			"<palette><palette_item index=\"1\" number=\"310\"/></palette></chart>", "Cœur", 5, 5},
+		{"no properties", `<chart><palette><palette_item index="1" number="310"/></palette></chart>`, "", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chart, err := ParseOXS(strings.NewReader(tt.doc))
+			if err != nil {
+				t.Fatalf("ParseOXS() error = %v", err)
+			}
+			if chart.Title != tt.title || chart.Width != tt.width || chart.Height != tt.height {
+				t.Errorf("got %q %dx%d, want %q %dx%d", chart.Title, chart.Width, chart.Height, tt.title, tt.width, tt.height)
+			}
+		})
+	}
+}
+
+func TestParseOXSWarningCap(t *testing.T) {
+	tests := []struct {
+		bad          int
+		wantWarnings int
+		wantSummary  string
+	}{
+		{0, 0, ""},
+		{maxOXSWarnings, maxOXSWarnings, ""},
+		{maxOXSWarnings + 1, maxOXSWarnings + 1, "1 more warnings omitted"},
+		{maxOXSWarnings + 30, maxOXSWarnings + 1, "30 more warnings omitted"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.bad), func(t *testing.T) {
+			var stitches strings.Builder
+			for i := 0; i < tt.bad; i++ {
+				fmt.Fprintf(&stitches, "<stitch x=\"%d\" y=\"0\" palindex=\"bad\"/>\n", i)
+			}
+			stitches.WriteString(`<stitch x="0" y="1" palindex="1"/>`)
+
+			chart, err := ParseOXS(strings.NewReader(oxsDoc(oxsTwoColors, stitches.String())))
+			if err != nil {
+				t.Fatalf("ParseOXS() error = %v", err)
+			}
+			if len(chart.Warnings) != tt.wantWarnings {
+				t.Fatalf("len(Warnings) = %d, want %d", len(chart.Warnings), tt.wantWarnings)
+			}
+			if tt.wantSummary != "" && chart.Warnings[len(chart.Warnings)-1] != tt.wantSummary {
+				t.Errorf("last warning = %q, want %q", chart.Warnings[len(chart.Warnings)-1], tt.wantSummary)
+			}
+			// Les points valides restent comptés malgré les anomalies
+			if got := chart.Palette[0].FullStitches; got != 1 {
+				t.Errorf("FullStitches = %d, want 1", got)
+			}
+		})
+	}
+}
+
+func TestOXSPaletteEntryStitches(t *testing.T) {
+	e := OXSPaletteEntry{FullStitches: 10, PartStitches: 3}
+	if got := e.Stitches(); math.Abs(got-11.5) > 1e-9 {
+		t.Errorf("Stitches() = %v, want 11.5", got)
+	}
+}
+
+func TestSplitOXSNumber(t *testing.T) {
+	tests := []struct {
+		value, brand, number string
+	}{
+		{"DMC 310", "DMC", "310"},
+		{"  Anchor   403 ", "Anchor", "403"},
+		{"310", "", "310"},
+		{"DMC Blanc Neige", "DMC", "Blanc Neige"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		brand, number := splitOXSNumber(tt.value)
+		if brand != tt.brand || number != tt.number {
+			t.Errorf("splitOXSNumber(%q) = (%q, %q), want (%q, %q)", tt.value, brand, number, tt.brand, tt.number)
+		}
+	}
+}
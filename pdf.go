@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image/color"
+	"io"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// pdfCanvas produit le flux de contenu d'une page PDF. Les polices standard
+// Helvetica (texte) et Courier-Bold (symboles) ne sont pas embarquées.
+type pdfCanvas struct {
+	buf bytes.Buffer
+}
+
+// y convertit une ordonnée depuis le haut de la page vers le repère PDF.
+func (p *pdfCanvas) y(v float64) float64 {
+	return chartPageHeight - v
+}
+
+func pdfColor(c color.RGBA) string {
+	return fmt.Sprintf("%.3f %.3f %.3f", float64(c.R)/255, float64(c.G)/255, float64(c.B)/255)
+}
+
+func (p *pdfCanvas) Rect(x, y, w, h float64, fill color.RGBA) {
+	fmt.Fprintf(&p.buf, "%s rg %.2f %.2f %.2f %.2f re f\n", pdfColor(fill), x, p.y(y+h), w, h)
+}
+
+func (p *pdfCanvas) Line(x1, y1, x2, y2, width float64, stroke color.RGBA) {
+	fmt.Fprintf(&p.buf, "%.2f w %s RG %.2f %.2f m %.2f %.2f l S\n", width, pdfColor(stroke), x1, p.y(y1), x2, p.y(y2))
+}
+
+func (p *pdfCanvas) Text(x, y, size float64, text string, fill color.RGBA) {
+	fmt.Fprintf(&p.buf, "BT /F1 %.1f Tf %s rg %.2f %.2f Td (%s) Tj ET\n", size, pdfColor(fill), x, p.y(y), pdfString(text))
+}
+
+func (p *pdfCanvas) Symbol(cx, cy, size float64, text string, fill color.RGBA) {
+	// Les glyphes de Courier font tous 600/1000 de la taille de police
+	width := 0.6 * size * float64(len(text))
+	fmt.Fprintf(&p.buf, "BT /F2 %.1f Tf %s rg %.2f %.2f Td (%s) Tj ET\n", size, pdfColor(fill), cx-width/2, p.y(cy+0.35*size), pdfString(text))
+}
+
+var pdfEncoder = encoding.ReplaceUnsupported(charmap.Windows1252.NewEncoder())
+
+// pdfString encode le texte en WinAnsi et échappe les caractères réservés.
+func pdfString(s string) string {
+	encoded, err := pdfEncoder.String(s)
+	if err != nil {
+		encoded = s
+	}
+	var b strings.Builder
+	for i := 0; i < len(encoded); i++ {
+		switch c := encoded[i]; {
+		case c == '\\' || c == '(' || c == ')':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20:
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// pdfWriter numérote les objets et mémorise leur position pour la table xref.
+type pdfWriter struct {
+	w       io.Writer
+	n       int64
+	offsets []int64
+	err     error
+}
+
+func (p *pdfWriter) write(format string, args ...any) {
+	if p.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(p.w, format, args...)
+	p.n += int64(n)
+	p.err = err
+}
+
+func (p *pdfWriter) object(id int, body string) {
+	p.offsets[id-1] = p.n
+	p.write("%d 0 obj\n%s\nendobj\n", id, body)
+}
+
+func (p *pdfWriter) stream(id int, data []byte) {
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	_, _ = zw.Write(data)
+	_ = zw.Close()
+
+	p.offsets[id-1] = p.n
+	p.write("%d 0 obj\n<< /Length %d /Filter /FlateDecode >>\nstream\n", id, compressed.Len())
+	if p.err == nil {
+		n, err := p.w.Write(compressed.Bytes())
+		p.n += int64(n)
+		p.err = err
+	}
+	p.write("\nendstream\nendobj\n")
+}
+
+func writeChartPDF(w io.Writer, layout *chartLayout) error {
+	pages := layout.Pages()
+	// 1 catalogue, 2 arbre des pages, 3 et 4 polices, puis une page et son contenu
+	p := &pdfWriter{w: w, offsets: make([]int64, 4+2*pages)}
+	p.write("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+
+	kids := make([]string, pages)
+	for i := range kids {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	p.object(1, "<< /Type /Catalog /Pages 2 0 R >>")
+	p.object(2, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), pages))
+	p.object(3, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	p.object(4, "<< /Type /Font /Subtype /Type1 /BaseFont /Courier-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i := 0; i < pages; i++ {
+		canvas := &pdfCanvas{}
+		layout.Draw(canvas, i)
+		p.object(5+2*i, fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			chartPageWidth, chartPageHeight, 6+2*i))
+		p.stream(6+2*i, canvas.buf.Bytes())
+	}
+
+	xref := p.n
+	p.write("xref\n0 %d\n0000000000 65535 f \n", len(p.offsets)+1)
+	for _, offset := range p.offsets {
+		p.write("%010d 00000 n \n", offset)
+	}
+	p.write("trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(p.offsets)+1, xref)
+	return p.err
+}
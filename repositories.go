@@ -2,8 +2,12 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"strconv"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // --- Account Repository ---
@@ -18,7 +22,7 @@ func NewAccountRepository(db *gorm.DB) UserRepository {
 
 func (r *accountRepository) GetByID(ctx context.Context, id uint) (*User, error) {
 	var user User
-	if err := r.db.WithContext(ctx).First(&user, id).Error; err != nil {
+	if err := dbFromContext(ctx, r.db).First(&user, id).Error; err != nil {
 		return nil, err
 	}
 	return &user, nil
@@ -26,18 +30,35 @@ func (r *accountRepository) GetByID(ctx context.Context, id uint) (*User, error)
 
 func (r *accountRepository) GetByEmail(ctx context.Context, email string) (*User, error) {
 	var user User
-	if err := r.db.WithContext(ctx).First(&user, "email = ?", email).Error; err != nil {
+	if err := dbFromContext(ctx, r.db).First(&user, "email = ?", email).Error; err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
 func (r *accountRepository) Create(ctx context.Context, user *User) error {
-	return r.db.WithContext(ctx).Create(user).Error
+	return dbFromContext(ctx, r.db).Create(user).Error
 }
 
 func (r *accountRepository) Update(ctx context.Context, user *User) error {
-	return r.db.WithContext(ctx).Model(user).Where("id = ?", user.ID).Updates(user).Error
+	return dbFromContext(ctx, r.db).Model(user).Where("id = ?", user.ID).Updates(user).Error
+}
+
+func (r *accountRepository) UpdateFields(ctx context.Context, id uint, fields map[string]any) error {
+	return dbFromContext(ctx, r.db).Model(&User{}).Where("id = ?", id).Updates(fields).Error
+}
+
+func (r *accountRepository) UpdateEmail(ctx context.Context, id uint, email string, verifiedAt time.Time) error {
+	db := dbFromContext(ctx, r.db)
+	err := db.Model(&User{}).Where("id = ?", id).Updates(map[string]any{
+		"email":             email,
+		"email_verified_at": verifiedAt,
+	}).Error
+	// La contrainte d'unicité tranche entre deux changements concurrents
+	if translator, ok := db.Dialector.(gorm.ErrorTranslator); ok && err != nil {
+		err = translator.Translate(err)
+	}
+	return err
 }
 
 // --- Thread Repository ---
@@ -52,7 +73,7 @@ func NewThreadRepository(db *gorm.DB) ThreadRepository {
 
 func (r *threadRepository) GetByID(ctx context.Context, id uint) (*Thread, error) {
 	var thread Thread
-	if err := r.db.WithContext(ctx).First(&thread, id).Error; err != nil {
+	if err := dbFromContext(ctx, r.db).First(&thread, id).Error; err != nil {
 		return nil, err
 	}
 	return &thread, nil
@@ -60,46 +81,840 @@ func (r *threadRepository) GetByID(ctx context.Context, id uint) (*Thread, error
 
 func (r *threadRepository) GetByUserID(ctx context.Context, userID uint) ([]Thread, error) {
 	var threads []Thread
-	if err := r.db.WithContext(ctx).Where("user_id = ?", userID).Find(&threads).Error; err != nil {
+	if err := dbFromContext(ctx, r.db).Preload("CatalogColor").Where("user_id = ?", userID).Find(&threads).Error; err != nil {
+		return nil, err
+	}
+	return threads, nil
+}
+
+func (r *threadRepository) List(ctx context.Context, userID uint, query ThreadListQuery) ([]Thread, int64, error) {
+	db := dbFromContext(ctx, r.db).Model(&Thread{}).Where("user_id = ?", userID)
+
+	if query.Brand != "" {
+		db = db.Where("brand = ?", query.Brand)
+	}
+	// Les anciens filtres is_e/is_c/is_s portent sur la présence du format
+	if query.IsE != nil {
+		db = db.Where("(skeins > 0) = ?", *query.IsE)
+	}
+	if query.IsC != nil {
+		db = db.Where("(cards > 0) = ?", *query.IsC)
+	}
+	if query.IsS != nil {
+		db = db.Where("(spools > 0) = ?", *query.IsS)
+	}
+	if query.MinCount != nil {
+		db = db.Where("thread_count >= ?", *query.MinCount)
+	}
+	if query.MaxCount != nil {
+		db = db.Where("thread_count <= ?", *query.MaxCount)
+	}
+	for _, tag := range query.Tags {
+		db = db.Where("id IN (?)", dbFromContext(ctx, r.db).Table("thread_tags").
+			Select("thread_tags.thread_id").
+			Joins("JOIN tags ON tags.id = thread_tags.tag_id").
+			Where("tags.user_id = ? AND tags.name = ?", userID, tag))
+	}
+	if query.Unlocated {
+		db = db.Where("location_id IS NULL")
+	} else if query.LocationID != nil {
+		db = db.Where("location_id IN ?", query.LocationIDs)
+	}
+
+	var total int64
+	if err := db.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	column := query.Sort
+	direction, cmp := "ASC", ">"
+	if query.Desc {
+		direction, cmp = "DESC", "<"
+	}
+
+	// Pagination par curseur (keyset) sur (colonne de tri, id)
+	if query.Cursor != nil {
+		value, err := threadCursorValue(column, query.Cursor.Value)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: invalid cursor value", ErrInvalidThreadQuery)
+		}
+		db = db.Where(fmt.Sprintf("(%[1]s %[2]s ?) OR (%[1]s = ? AND id %[2]s ?)", column, cmp), value, value, query.Cursor.ID)
+	}
+
+	var threads []Thread
+	err := db.Preload("CatalogColor").Preload("Tags").
+		Order(fmt.Sprintf("%s %s, id %s", column, direction, direction)).
+		Limit(query.Limit).
+		Find(&threads).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return threads, total, nil
+}
+
+func (r *threadRepository) StreamByUserID(ctx context.Context, userID uint, batchSize int, fn func([]Thread) error) error {
+	var batch []Thread
+	return dbFromContext(ctx, r.db).
+		Where("user_id = ?", userID).
+		Order("id").
+		FindInBatches(&batch, batchSize, func(tx *gorm.DB, _ int) error {
+			return fn(batch)
+		}).Error
+}
+
+func threadCursorValue(column, raw string) (any, error) {
+	switch column {
+	case "thread_count":
+		return strconv.ParseInt(raw, 10, 64)
+	case "updated_at":
+		return time.Parse(time.RFC3339Nano, raw)
+	default:
+		return raw, nil
+	}
+}
+
+func (r *threadRepository) GetByKeys(ctx context.Context, userID uint, keys []ThreadKey) ([]Thread, error) {
+	var threads []Thread
+	if len(keys) == 0 {
+		return threads, nil
+	}
+	if err := dbFromContext(ctx, r.db).Where("user_id = ? AND (brand, thread_id) IN ?", userID, threadKeyPairs(keys)).Find(&threads).Error; err != nil {
 		return nil, err
 	}
 	return threads, nil
 }
 
-func (r *threadRepository) Create(ctx context.Context, thread *Thread) error {
+func threadKeyPairs(keys []ThreadKey) [][]any {
+	pairs := make([][]any, len(keys))
+	for i, k := range keys {
+		pairs[i] = []any{k.Brand, k.ThreadId}
+	}
+	return pairs
+}
+
+func (r *threadRepository) Create(ctx context.Context, thread *Thread) (bool, error) {
 	var existing Thread
-	err := r.db.WithContext(ctx).Unscoped().Where("user_id = ? AND thread_id = ?", thread.UserID, thread.ThreadId).First(&existing).Error
+	err := dbFromContext(ctx, r.db).Unscoped().Where("user_id = ? AND brand = ? AND thread_id = ?", thread.UserID, thread.Brand, thread.ThreadId).First(&existing).Error
 
 	if err == nil {
 		// Le thread existe déjà (peut-être supprimé)
 		if existing.DeletedAt.Valid {
 			// Il était supprimé, on le restaure
 			thread.ID = existing.ID
-			return r.db.WithContext(ctx).Unscoped().Model(&existing).Updates(map[string]any{
-				"deleted_at":   nil,
-				"is_e":         thread.IsE,
-				"is_c":         thread.IsC,
-				"is_s":         thread.IsS,
-				"brand":        thread.Brand,
-				"thread_count": thread.ThreadCount,
+			thread.CreatedAt = existing.CreatedAt
+			return true, dbFromContext(ctx, r.db).Unscoped().Model(&existing).Updates(map[string]any{
+				"deleted_at":       nil,
+				"thread_count":     thread.ThreadCount,
+				"skeins":           thread.Skeins,
+				"cards":            thread.Cards,
+				"spools":           thread.Spools,
+				"partial_metres":   thread.PartialMetres,
+				"is_custom":        thread.IsCustom,
+				"catalog_color_id": thread.CatalogColorID,
+				"min_quantity":     thread.MinQuantity,
+				"location_id":      thread.LocationID,
 			}).Error
 		}
 		// Il n'est pas supprimé, on laisse GORM renvoyer l'erreur de contrainte unique
 	}
 
-	return r.db.WithContext(ctx).Create(thread).Error
+	return false, dbFromContext(ctx, r.db).Create(thread).Error
 }
 
 func (r *threadRepository) Update(ctx context.Context, thread *Thread) error {
-	return r.db.WithContext(ctx).Model(thread).Where("user_id = ?", thread.UserID).Updates(thread).Error
+	// Select force l'écriture des valeurs nulles (false, 0) que Updates ignore sinon
+	result := dbFromContext(ctx, r.db).Model(thread).
+		Where("user_id = ?", thread.UserID).
+		Select("thread_id", "brand", "thread_count", "skeins", "cards", "spools", "partial_metres", "is_custom", "catalog_color_id", "min_quantity", "location_id").
+		Updates(thread)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *threadRepository) Patch(ctx context.Context, userID uint, id uint, fields map[string]any) error {
+	result := dbFromContext(ctx, r.db).Model(&Thread{}).Where("id = ? AND user_id = ?", id, userID).Updates(fields)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *threadRepository) GetByIDs(ctx context.Context, userID uint, ids []uint) ([]Thread, error) {
+	var threads []Thread
+	if len(ids) == 0 {
+		return threads, nil
+	}
+	if err := dbFromContext(ctx, r.db).Where("user_id = ? AND id IN ?", userID, ids).Find(&threads).Error; err != nil {
+		return nil, err
+	}
+	return threads, nil
+}
+
+func (r *threadRepository) SetLocation(ctx context.Context, userID uint, ids []uint, locationID *uint) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	result := dbFromContext(ctx, r.db).Model(&Thread{}).
+		Where("user_id = ? AND id IN ?", userID, ids).
+		Update("location_id", locationID)
+	return result.RowsAffected, result.Error
+}
+
+func (r *threadRepository) MoveLocationContents(ctx context.Context, userID uint, from uint, locationID *uint) (int64, error) {
+	result := dbFromContext(ctx, r.db).Model(&Thread{}).
+		Where("user_id = ? AND location_id = ?", userID, from).
+		Update("location_id", locationID)
+	return result.RowsAffected, result.Error
 }
 
 func (r *threadRepository) Delete(ctx context.Context, userID uint, id uint) error {
-	return r.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&Thread{}, id).Error
+	return dbFromContext(ctx, r.db).Where("user_id = ?", userID).Delete(&Thread{}, id).Error
+}
+
+func (r *threadRepository) DeleteMultiple(ctx context.Context, userID uint, keys []ThreadKey) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return dbFromContext(ctx, r.db).Where("user_id = ? AND (brand, thread_id) IN ?", userID, threadKeyPairs(keys)).Delete(&Thread{}).Error
+}
+
+func (r *threadRepository) GetLowStock(ctx context.Context, userID uint) ([]Thread, error) {
+	var threads []Thread
+	err := dbFromContext(ctx, r.db).
+		Preload("CatalogColor").
+		Where("user_id = ? AND low_stock = ?", userID, true).
+		Order("brand, thread_id").
+		Find(&threads).Error
+	if err != nil {
+		return nil, err
+	}
+	return threads, nil
+}
+
+func (r *threadRepository) GetTrash(ctx context.Context, userID uint) ([]Thread, error) {
+	var threads []Thread
+	err := dbFromContext(ctx, r.db).Unscoped().
+		Preload("CatalogColor").
+		Where("user_id = ? AND deleted_at IS NOT NULL", userID).
+		Order("deleted_at DESC").
+		Find(&threads).Error
+	if err != nil {
+		return nil, err
+	}
+	return threads, nil
+}
+
+func (r *threadRepository) GetTrashedByID(ctx context.Context, userID uint, id uint) (*Thread, error) {
+	var thread Thread
+	if err := dbFromContext(ctx, r.db).Unscoped().First(&thread, "id = ? AND user_id = ? AND deleted_at IS NOT NULL", id, userID).Error; err != nil {
+		return nil, err
+	}
+	return &thread, nil
+}
+
+func (r *threadRepository) Restore(ctx context.Context, userID uint, id uint) error {
+	result := dbFromContext(ctx, r.db).Unscoped().Model(&Thread{}).
+		Where("id = ? AND user_id = ? AND deleted_at IS NOT NULL", id, userID).
+		Update("deleted_at", nil)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *threadRepository) GetTrashedBefore(ctx context.Context, cutoff time.Time) ([]uint, error) {
+	var ids []uint
+	err := dbFromContext(ctx, r.db).Unscoped().Model(&Thread{}).
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Purge supprime définitivement des fils déjà en corbeille.
+func (r *threadRepository) Purge(ctx context.Context, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	db := dbFromContext(ctx, r.db)
+	if err := db.Exec("DELETE FROM thread_tags WHERE thread_id IN ?", ids).Error; err != nil {
+		return err
+	}
+	return db.Unscoped().Where("id IN ? AND deleted_at IS NOT NULL", ids).Delete(&Thread{}).Error
+}
+
+// --- Stock Movement Repository ---
+
+type stockMovementRepository struct {
+	db *gorm.DB
+}
+
+func NewStockMovementRepository(db *gorm.DB) StockMovementRepository {
+	return &stockMovementRepository{db: db}
+}
+
+func (r *stockMovementRepository) Create(ctx context.Context, movement *StockMovement) error {
+	return dbFromContext(ctx, r.db).Create(movement).Error
+}
+
+func (r *stockMovementRepository) GetByThreadID(ctx context.Context, userID uint, threadID uint) ([]StockMovement, error) {
+	var movements []StockMovement
+	err := dbFromContext(ctx, r.db).
+		Where("user_id = ? AND thread_id = ?", userID, threadID).
+		Order("created_at DESC, id DESC").
+		Find(&movements).Error
+	if err != nil {
+		return nil, err
+	}
+	return movements, nil
+}
+
+func (r *stockMovementRepository) DeleteByThreadIDs(ctx context.Context, threadIDs []uint) error {
+	if len(threadIDs) == 0 {
+		return nil
+	}
+	return dbFromContext(ctx, r.db).Where("thread_id IN ?", threadIDs).Delete(&StockMovement{}).Error
+}
+
+// --- Chart Repository ---
+
+type chartRepository struct {
+	db *gorm.DB
+}
+
+func NewChartRepository(db *gorm.DB) ChartRepository {
+	return &chartRepository{db: db}
+}
+
+func (r *chartRepository) GetByUserID(ctx context.Context, userID uint) ([]Chart, error) {
+	var charts []Chart
+	if err := dbFromContext(ctx, r.db).Omit("cells").Where("user_id = ?", userID).Order("created_at DESC").Find(&charts).Error; err != nil {
+		return nil, err
+	}
+	return charts, nil
+}
+
+func (r *chartRepository) GetByID(ctx context.Context, userID uint, id uint) (*Chart, error) {
+	var chart Chart
+	if err := dbFromContext(ctx, r.db).First(&chart, "id = ? AND user_id = ?", id, userID).Error; err != nil {
+		return nil, err
+	}
+	return &chart, nil
+}
+
+func (r *chartRepository) Create(ctx context.Context, chart *Chart) error {
+	return dbFromContext(ctx, r.db).Create(chart).Error
+}
+
+func (r *chartRepository) Delete(ctx context.Context, userID uint, id uint) error {
+	result := dbFromContext(ctx, r.db).Where("user_id = ?", userID).Delete(&Chart{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// --- Project Repository ---
+
+type projectRepository struct {
+	db *gorm.DB
+}
+
+func NewProjectRepository(db *gorm.DB) ProjectRepository {
+	return &projectRepository{db: db}
+}
+
+func (r *projectRepository) GetByUserID(ctx context.Context, userID uint) ([]Project, error) {
+	var projects []Project
+	if err := dbFromContext(ctx, r.db).Preload("Requirements").Where("user_id = ?", userID).Order("created_at DESC").Find(&projects).Error; err != nil {
+		return nil, err
+	}
+	return projects, nil
+}
+
+func (r *projectRepository) GetByID(ctx context.Context, userID uint, id uint) (*Project, error) {
+	var project Project
+	if err := dbFromContext(ctx, r.db).Preload("Requirements").First(&project, "id = ? AND user_id = ?", id, userID).Error; err != nil {
+		return nil, err
+	}
+	return &project, nil
+}
+
+func (r *projectRepository) Create(ctx context.Context, project *Project) error {
+	return dbFromContext(ctx, r.db).Create(project).Error
+}
+
+// Update remplace le projet et la totalité de ses besoins.
+func (r *projectRepository) Update(ctx context.Context, project *Project) error {
+	db := dbFromContext(ctx, r.db)
+	result := db.Model(project).Where("user_id = ?", project.UserID).Select("name", "description").Updates(project)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	if err := db.Where("project_id = ?", project.ID).Delete(&ProjectRequirement{}).Error; err != nil {
+		return err
+	}
+	for i := range project.Requirements {
+		project.Requirements[i].ProjectID = project.ID
+	}
+	if len(project.Requirements) == 0 {
+		return nil
+	}
+	return db.Create(&project.Requirements).Error
+}
+
+func (r *projectRepository) Delete(ctx context.Context, userID uint, id uint) error {
+	result := dbFromContext(ctx, r.db).Where("user_id = ?", userID).Delete(&Project{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// --- Tag Repository ---
+
+type tagRepository struct {
+	db *gorm.DB
+}
+
+func NewTagRepository(db *gorm.DB) TagRepository {
+	return &tagRepository{db: db}
+}
+
+func (r *tagRepository) GetByUserID(ctx context.Context, userID uint) ([]Tag, error) {
+	var tags []Tag
+	if err := dbFromContext(ctx, r.db).Where("user_id = ?", userID).Order("name").Find(&tags).Error; err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+func (r *tagRepository) GetByIDs(ctx context.Context, userID uint, ids []uint) ([]Tag, error) {
+	var tags []Tag
+	if len(ids) == 0 {
+		return tags, nil
+	}
+	if err := dbFromContext(ctx, r.db).Where("user_id = ? AND id IN ?", userID, ids).Find(&tags).Error; err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+func (r *tagRepository) Create(ctx context.Context, tag *Tag) error {
+	return dbFromContext(ctx, r.db).Create(tag).Error
+}
+
+func (r *tagRepository) Update(ctx context.Context, tag *Tag) error {
+	result := dbFromContext(ctx, r.db).Model(tag).Where("user_id = ?", tag.UserID).Select("name", "color").Updates(tag)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *tagRepository) Delete(ctx context.Context, userID uint, id uint) error {
+	db := dbFromContext(ctx, r.db)
+	if err := db.Exec("DELETE FROM thread_tags WHERE tag_id IN (SELECT id FROM tags WHERE id = ? AND user_id = ?)", id, userID).Error; err != nil {
+		return err
+	}
+	result := db.Where("user_id = ?", userID).Delete(&Tag{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *tagRepository) Attach(ctx context.Context, threadIDs []uint, tagIDs []uint) error {
+	rows := make([]map[string]any, 0, len(threadIDs)*len(tagIDs))
+	for _, threadID := range threadIDs {
+		for _, tagID := range tagIDs {
+			rows = append(rows, map[string]any{"thread_id": threadID, "tag_id": tagID})
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return dbFromContext(ctx, r.db).Table("thread_tags").Clauses(clause.OnConflict{DoNothing: true}).Create(rows).Error
+}
+
+func (r *tagRepository) Detach(ctx context.Context, threadIDs []uint, tagIDs []uint) error {
+	if len(threadIDs) == 0 || len(tagIDs) == 0 {
+		return nil
+	}
+	return dbFromContext(ctx, r.db).Exec("DELETE FROM thread_tags WHERE thread_id IN ? AND tag_id IN ?", threadIDs, tagIDs).Error
+}
+
+// --- Location Repository ---
+
+type locationRepository struct {
+	db *gorm.DB
+}
+
+func NewLocationRepository(db *gorm.DB) LocationRepository {
+	return &locationRepository{db: db}
+}
+
+func (r *locationRepository) GetByUserID(ctx context.Context, userID uint) ([]Location, error) {
+	var locations []Location
+	if err := dbFromContext(ctx, r.db).Where("user_id = ?", userID).Order("name, id").Find(&locations).Error; err != nil {
+		return nil, err
+	}
+	return locations, nil
+}
+
+func (r *locationRepository) GetByID(ctx context.Context, userID uint, id uint) (*Location, error) {
+	var location Location
+	if err := dbFromContext(ctx, r.db).First(&location, "id = ? AND user_id = ?", id, userID).Error; err != nil {
+		return nil, err
+	}
+	return &location, nil
+}
+
+func (r *locationRepository) Create(ctx context.Context, location *Location) error {
+	return dbFromContext(ctx, r.db).Create(location).Error
+}
+
+func (r *locationRepository) Update(ctx context.Context, location *Location) error {
+	result := dbFromContext(ctx, r.db).Model(location).
+		Where("user_id = ?", location.UserID).
+		Select("name", "kind", "parent_id").
+		Updates(location)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *locationRepository) Delete(ctx context.Context, userID uint, id uint) error {
+	result := dbFromContext(ctx, r.db).Where("user_id = ?", userID).Delete(&Location{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// --- Shopping List Repository ---
+
+type shoppingListRepository struct {
+	db *gorm.DB
+}
+
+func NewShoppingListRepository(db *gorm.DB) ShoppingListRepository {
+	return &shoppingListRepository{db: db}
+}
+
+func (r *shoppingListRepository) GetByUserID(ctx context.Context, userID uint, includeBought bool) ([]ShoppingListItem, error) {
+	var items []ShoppingListItem
+	db := dbFromContext(ctx, r.db).Where("user_id = ?", userID)
+	if !includeBought {
+		db = db.Where("bought_at IS NULL")
+	}
+	if err := db.Order("bought_at DESC NULLS FIRST, brand, thread_id").Find(&items).Error; err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (r *shoppingListRepository) GetOpenByIDs(ctx context.Context, userID uint, ids []uint) ([]ShoppingListItem, error) {
+	var items []ShoppingListItem
+	if len(ids) == 0 {
+		return items, nil
+	}
+	if err := dbFromContext(ctx, r.db).Where("user_id = ? AND id IN ? AND bought_at IS NULL", userID, ids).Find(&items).Error; err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (r *shoppingListRepository) GetOpenByKey(ctx context.Context, userID uint, key ThreadKey) (*ShoppingListItem, error) {
+	var item ShoppingListItem
+	err := dbFromContext(ctx, r.db).
+		Where("user_id = ? AND brand = ? AND thread_id = ? AND bought_at IS NULL", userID, key.Brand, key.ThreadId).
+		First(&item).Error
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func (r *shoppingListRepository) Create(ctx context.Context, item *ShoppingListItem) error {
+	return dbFromContext(ctx, r.db).Create(item).Error
+}
+
+func (r *shoppingListRepository) Update(ctx context.Context, item *ShoppingListItem) error {
+	return dbFromContext(ctx, r.db).Model(item).Where("user_id = ?", item.UserID).Select("quantity", "source", "note").Updates(item).Error
+}
+
+func (r *shoppingListRepository) MarkBought(ctx context.Context, userID uint, ids []uint, at time.Time) error {
+	return dbFromContext(ctx, r.db).Model(&ShoppingListItem{}).
+		Where("user_id = ? AND id IN ? AND bought_at IS NULL", userID, ids).
+		Update("bought_at", at).Error
+}
+
+func (r *shoppingListRepository) Delete(ctx context.Context, userID uint, id uint) error {
+	result := dbFromContext(ctx, r.db).Where("user_id = ?", userID).Delete(&ShoppingListItem{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *shoppingListRepository) DeleteOpenAuto(ctx context.Context, userID uint, key ThreadKey) error {
+	return dbFromContext(ctx, r.db).
+		Where("user_id = ? AND brand = ? AND thread_id = ? AND bought_at IS NULL AND source = ?", userID, key.Brand, key.ThreadId, ShoppingSourceAuto).
+		Delete(&ShoppingListItem{}).Error
+}
+
+func (r *shoppingListRepository) DeleteOpenAutoByThreadIDs(ctx context.Context, threadIDs []uint) error {
+	if len(threadIDs) == 0 {
+		return nil
+	}
+	return dbFromContext(ctx, r.db).
+		Where("bought_at IS NULL AND source = ?", ShoppingSourceAuto).
+		Where("(user_id, brand, thread_id) IN (?)", dbFromContext(ctx, r.db).Unscoped().Model(&Thread{}).
+			Select("user_id, brand, thread_id").
+			Where("id IN ?", threadIDs)).
+		Delete(&ShoppingListItem{}).Error
+}
+
+// --- Catalog Repository ---
+
+type catalogRepository struct {
+	db *gorm.DB
+}
+
+func NewCatalogRepository(db *gorm.DB) CatalogRepository {
+	return &catalogRepository{db: db}
+}
+
+func (r *catalogRepository) UpsertBrand(ctx context.Context, brand *Brand) error {
+	return dbFromContext(ctx, r.db).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "code"}},
+		DoUpdates: clause.AssignmentColumns([]string{"name", "skein_length", "strands"}),
+	}).Create(brand).Error
+}
+
+func (r *catalogRepository) UpsertColors(ctx context.Context, colors []CatalogColor) error {
+	if len(colors) == 0 {
+		return nil
+	}
+	return dbFromContext(ctx, r.db).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "brand_id"}, {Name: "number"}},
+		DoUpdates: clause.AssignmentColumns([]string{"name", "hex", "r", "g", "b", "discontinued"}),
+	}).CreateInBatches(colors, 200).Error
 }
 
-func (r *threadRepository) DeleteMultiple(ctx context.Context, userID uint, ids []string) error {
-	return r.db.WithContext(ctx).Where("user_id = ? AND thread_id IN ?", userID, ids).Delete(&Thread{}).Error
+func (r *catalogRepository) GetBrands(ctx context.Context) ([]Brand, error) {
+	var brands []Brand
+	if err := dbFromContext(ctx, r.db).Order("name").Find(&brands).Error; err != nil {
+		return nil, err
+	}
+	return brands, nil
+}
+
+func (r *catalogRepository) GetBrandByCode(ctx context.Context, code string) (*Brand, error) {
+	var brand Brand
+	if err := dbFromContext(ctx, r.db).First(&brand, "code = ?", code).Error; err != nil {
+		return nil, err
+	}
+	return &brand, nil
+}
+
+func (r *catalogRepository) GetColors(ctx context.Context, brandID uint, search string, includeDiscontinued bool) ([]CatalogColor, error) {
+	db := dbFromContext(ctx, r.db).Where("brand_id = ?", brandID)
+	if search != "" {
+		like := "%" + search + "%"
+		db = db.Where("number ILIKE ? OR name ILIKE ?", like, like)
+	}
+	if !includeDiscontinued {
+		db = db.Where("discontinued = ?", false)
+	}
+
+	var colors []CatalogColor
+	// Tri numérique quand c'est possible (310 avant 3865), sinon alphabétique
+	if err := db.Order("LENGTH(number), number").Find(&colors).Error; err != nil {
+		return nil, err
+	}
+	return colors, nil
+}
+
+func (r *catalogRepository) GetColor(ctx context.Context, brandID uint, number string) (*CatalogColor, error) {
+	var color CatalogColor
+	if err := dbFromContext(ctx, r.db).Preload("Brand").First(&color, "brand_id = ? AND UPPER(number) = UPPER(?)", brandID, number).Error; err != nil {
+		return nil, err
+	}
+	return &color, nil
+}
+
+func (r *catalogRepository) GetColorByID(ctx context.Context, id uint) (*CatalogColor, error) {
+	var color CatalogColor
+	if err := dbFromContext(ctx, r.db).Preload("Brand").First(&color, id).Error; err != nil {
+		return nil, err
+	}
+	return &color, nil
+}
+
+func (r *catalogRepository) UpsertConversions(ctx context.Context, conversions []ColorConversion) error {
+	if len(conversions) == 0 {
+		return nil
+	}
+	return dbFromContext(ctx, r.db).Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(conversions, 200).Error
+}
+
+func (r *catalogRepository) GetConversions(ctx context.Context, fromColorID uint, toBrandID uint) ([]CatalogColor, error) {
+	var colors []CatalogColor
+	err := dbFromContext(ctx, r.db).
+		Joins("JOIN color_conversions ON color_conversions.to_color_id = catalog_colors.id").
+		Where("color_conversions.from_color_id = ? AND catalog_colors.brand_id = ?", fromColorID, toBrandID).
+		Order("catalog_colors.number").
+		Find(&colors).Error
+	if err != nil {
+		return nil, err
+	}
+	return colors, nil
+}
+
+// --- Session Repository ---
+
+type sessionRepository struct {
+	db *gorm.DB
+}
+
+func NewSessionRepository(db *gorm.DB) SessionRepository {
+	return &sessionRepository{db: db}
+}
+
+func (r *sessionRepository) Create(ctx context.Context, session *Session) error {
+	return dbFromContext(ctx, r.db).Create(session).Error
+}
+
+func (r *sessionRepository) GetByID(ctx context.Context, id uint) (*Session, error) {
+	var session Session
+	if err := dbFromContext(ctx, r.db).First(&session, id).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *sessionRepository) GetByJTI(ctx context.Context, jti string) (*Session, error) {
+	var session Session
+	// L'utilisateur est joint pour que le middleware connaisse l'état de son adresse
+	if err := dbFromContext(ctx, r.db).Joins("User").First(&session, "sessions.jti = ?", jti).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *sessionRepository) GetActiveByUserID(ctx context.Context, userID uint, now time.Time) ([]Session, error) {
+	var sessions []Session
+	err := dbFromContext(ctx, r.db).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, now).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (r *sessionRepository) Touch(ctx context.Context, id uint, at time.Time) error {
+	return dbFromContext(ctx, r.db).Model(&Session{}).Where("id = ?", id).Update("last_seen_at", at).Error
+}
+
+func (r *sessionRepository) Renew(ctx context.Context, id uint, jti string, expiresAt time.Time, at time.Time) error {
+	return dbFromContext(ctx, r.db).Model(&Session{}).Where("id = ?", id).Updates(map[string]any{
+		"jti":          jti,
+		"expires_at":   expiresAt,
+		"last_seen_at": at,
+	}).Error
+}
+
+func (r *sessionRepository) Revoke(ctx context.Context, userID uint, id uint, at time.Time) error {
+	result := dbFromContext(ctx, r.db).Model(&Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", at)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *sessionRepository) RevokeOthers(ctx context.Context, userID uint, keepID uint, at time.Time) (int64, error) {
+	result := dbFromContext(ctx, r.db).Model(&Session{}).
+		Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, keepID).
+		Update("revoked_at", at)
+	return result.RowsAffected, result.Error
+}
+
+// --- Refresh Token Repository ---
+
+type refreshTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewRefreshTokenRepository(db *gorm.DB) RefreshTokenRepository {
+	return &refreshTokenRepository{db: db}
+}
+
+func (r *refreshTokenRepository) Create(ctx context.Context, token *RefreshToken) error {
+	return dbFromContext(ctx, r.db).Create(token).Error
+}
+
+func (r *refreshTokenRepository) GetByHash(ctx context.Context, hash string) (*RefreshToken, error) {
+	var token RefreshToken
+	if err := dbFromContext(ctx, r.db).First(&token, "token_hash = ?", hash).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// MarkRotated ne consomme le jeton que s'il ne l'a pas déjà été, pour que deux
+// rafraîchissements concurrents ne puissent pas tous deux réussir.
+func (r *refreshTokenRepository) MarkRotated(ctx context.Context, id uint, at time.Time) (bool, error) {
+	result := dbFromContext(ctx, r.db).Model(&RefreshToken{}).
+		Where("id = ? AND rotated_at IS NULL", id).
+		Update("rotated_at", at)
+	return result.RowsAffected == 1, result.Error
 }
 
 // --- Password Reset Repository ---
@@ -113,17 +928,85 @@ func NewPasswordResetRepository(db *gorm.DB) PasswordResetTokenRepository {
 }
 
 func (r *passwordResetRepository) Create(ctx context.Context, token *PasswordResetToken) error {
-	return r.db.WithContext(ctx).Create(token).Error
+	return dbFromContext(ctx, r.db).Create(token).Error
 }
 
 func (r *passwordResetRepository) GetByToken(ctx context.Context, token string) (*PasswordResetToken, error) {
 	var prt PasswordResetToken
-	if err := r.db.WithContext(ctx).Preload("User").First(&prt, "token = ?", token).Error; err != nil {
+	if err := dbFromContext(ctx, r.db).Preload("User").First(&prt, "token = ?", token).Error; err != nil {
 		return nil, err
 	}
 	return &prt, nil
 }
 
 func (r *passwordResetRepository) DeleteByUserID(ctx context.Context, userID uint) error {
-	return r.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&PasswordResetToken{}).Error
+	return dbFromContext(ctx, r.db).Where("user_id = ?", userID).Delete(&PasswordResetToken{}).Error
+}
+
+// --- Email Change Repository ---
+
+type emailChangeRepository struct {
+	db *gorm.DB
+}
+
+func NewEmailChangeRepository(db *gorm.DB) EmailChangeRepository {
+	return &emailChangeRepository{db: db}
+}
+
+func (r *emailChangeRepository) Create(ctx context.Context, request *EmailChangeRequest) error {
+	return dbFromContext(ctx, r.db).Create(request).Error
+}
+
+func (r *emailChangeRepository) GetByConfirmHash(ctx context.Context, hash string) (*EmailChangeRequest, error) {
+	var request EmailChangeRequest
+	if err := dbFromContext(ctx, r.db).Preload("User").First(&request, "confirm_hash = ?", hash).Error; err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+func (r *emailChangeRepository) GetByCancelHash(ctx context.Context, hash string) (*EmailChangeRequest, error) {
+	var request EmailChangeRequest
+	if err := dbFromContext(ctx, r.db).Preload("User").First(&request, "cancel_hash = ?", hash).Error; err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+func (r *emailChangeRepository) DeleteByUserID(ctx context.Context, userID uint) error {
+	return dbFromContext(ctx, r.db).Where("user_id = ?", userID).Delete(&EmailChangeRequest{}).Error
+}
+
+// --- Email Verification Repository ---
+
+type emailVerificationRepository struct {
+	db *gorm.DB
+}
+
+func NewEmailVerificationRepository(db *gorm.DB) EmailVerificationRepository {
+	return &emailVerificationRepository{db: db}
+}
+
+func (r *emailVerificationRepository) Create(ctx context.Context, token *EmailVerificationToken) error {
+	return dbFromContext(ctx, r.db).Create(token).Error
+}
+
+func (r *emailVerificationRepository) GetByHash(ctx context.Context, hash string) (*EmailVerificationToken, error) {
+	var token EmailVerificationToken
+	if err := dbFromContext(ctx, r.db).Preload("User").First(&token, "token_hash = ?", hash).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *emailVerificationRepository) GetLatestByUserID(ctx context.Context, userID uint) (*EmailVerificationToken, error) {
+	var token EmailVerificationToken
+	if err := dbFromContext(ctx, r.db).Where("user_id = ?", userID).Order("created_at DESC").First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *emailVerificationRepository) DeleteByUserID(ctx context.Context, userID uint) error {
+	return dbFromContext(ctx, r.db).Where("user_id = ?", userID).Delete(&EmailVerificationToken{}).Error
 }
@@ -1,63 +1,93 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"math"
+	"net/mail"
 	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/codes"
 	"golang.org/x/crypto/bcrypt"
-)
+	"gorm.io/gorm"
 
-func GetSecretKey() []byte {
-	return []byte(os.Getenv("SECRET_KEY"))
-}
+	"threadStocks/skein"
+)
 
 // --- Account Service ---
 
+var (
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+	ErrEmailAlreadyVerified     = errors.New("email address already verified")
+	ErrVerificationThrottled    = errors.New("verification email sent recently, try again later")
+	ErrInvalidEmailChange       = errors.New("invalid email change")
+	ErrEmailTaken               = errors.New("email address already in use")
+	ErrInvalidCurrentPassword   = errors.New("invalid current password")
+)
+
+const (
+	emailVerificationLifetime = 24 * time.Hour
+	// Délai minimal entre deux envois de l'email de vérification
+	verificationResendInterval = time.Minute
+	emailChangeLifetime        = 24 * time.Hour
+)
+
 type AccountService struct {
 	repo         UserRepository
 	resetRepo    PasswordResetTokenRepository
+	verifyRepo   EmailVerificationRepository
+	changeRepo   EmailChangeRepository
+	sessions     *SessionService
 	emailService *EmailService
 	log          *slog.Logger
 }
 
-func NewAccountService(repo UserRepository, resetRepo PasswordResetTokenRepository, emailService *EmailService, log *slog.Logger) *AccountService {
-	return &AccountService{repo: repo, resetRepo: resetRepo, emailService: emailService, log: log}
+func NewAccountService(repo UserRepository, resetRepo PasswordResetTokenRepository, verifyRepo EmailVerificationRepository, changeRepo EmailChangeRepository, sessions *SessionService, emailService *EmailService, log *slog.Logger) *AccountService {
+	return &AccountService{repo: repo, resetRepo: resetRepo, verifyRepo: verifyRepo, changeRepo: changeRepo, sessions: sessions, emailService: emailService, log: log}
 }
 
 func (s *AccountService) GetUserByID(ctx context.Context, id uint) (*User, error) {
 	return s.repo.GetByID(ctx, id)
 }
 
-func (s *AccountService) Login(ctx context.Context, email, password string) (string, error) {
+func (s *AccountService) Login(ctx context.Context, email, password string, info SessionInfo) (*TokenPair, error) {
 	user, err := s.repo.GetByEmail(ctx, email)
 	if err != nil {
-		return "", errors.New("invalid credentials")
+		return nil, errors.New("invalid credentials")
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return "", errors.New("invalid credentials")
+		return nil, errors.New("invalid credentials")
 	}
 
-	return s.createToken(user.ID)
+	return s.sessions.Create(ctx, user.ID, info)
 }
 
-func (s *AccountService) Register(ctx context.Context, req RegisterDto) (string, error) {
+func (s *AccountService) Register(ctx context.Context, req RegisterDto, info SessionInfo) (*TokenPair, error) {
 	if req.Password != req.ConfirmPassword {
-		return "", errors.New("passwords do not match")
+		return nil, errors.New("passwords do not match")
 	}
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), 14)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	user := &User{
@@ -67,21 +97,110 @@ func (s *AccountService) Register(ctx context.Context, req RegisterDto) (string,
 	}
 
 	if err := s.repo.Create(ctx, user); err != nil {
-		return "", err
+		return nil, err
+	}
+
+	// Le compte existe déjà : un échec d'envoi se rattrape avec un renvoi
+	if err := s.sendVerification(ctx, user); err != nil {
+		s.log.Error("Failed to create email verification token", "error", err, "user_id", user.ID)
 	}
 
-	return s.createToken(user.ID)
+	return s.sessions.Create(ctx, user.ID, info)
 }
 
-func (s *AccountService) createToken(userID uint) (string, error) {
-	claims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"sub": fmt.Sprintf("%d", userID),
-		"iss": "threadStocks",
-		"exp": time.Now().Add(time.Hour * 72).Unix(),
-		"iat": time.Now().Unix(),
-	})
+// VerifyEmail confirme l'adresse du compte auquel le jeton a été envoyé.
+func (s *AccountService) VerifyEmail(ctx context.Context, token string) error {
+	ctx, span := otel.Tracer("account-service").Start(ctx, "VerifyEmail")
+	defer span.End()
+
+	verification, err := s.verifyRepo.GetByHash(ctx, hashToken(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidVerificationToken
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	if verification.ExpiresAt.Before(time.Now()) {
+		_ = s.verifyRepo.DeleteByUserID(ctx, verification.UserID)
+		return ErrInvalidVerificationToken
+	}
+
+	if verification.User.EmailVerifiedAt == nil {
+		if err := s.repo.UpdateFields(ctx, verification.UserID, map[string]any{"email_verified_at": time.Now()}); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return err
+		}
+	}
+
+	_ = s.verifyRepo.DeleteByUserID(ctx, verification.UserID)
+	return nil
+}
+
+// ResendVerification renvoie un lien de vérification, au plus une fois par
+// verificationResendInterval. Le lien précédent est invalidé.
+func (s *AccountService) ResendVerification(ctx context.Context, userID uint) error {
+	ctx, span := otel.Tracer("account-service").Start(ctx, "ResendVerification")
+	defer span.End()
+
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerifiedAt != nil {
+		return ErrEmailAlreadyVerified
+	}
+
+	latest, err := s.verifyRepo.GetLatestByUserID(ctx, userID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if err == nil && time.Since(latest.CreatedAt) < verificationResendInterval {
+		return ErrVerificationThrottled
+	}
+
+	if err := s.sendVerification(ctx, user); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	return nil
+}
+
+// sendVerification remplace les jetons de vérification du compte et envoie
+// le nouveau lien en arrière-plan.
+func (s *AccountService) sendVerification(ctx context.Context, user *User) error {
+	token := s.generateSecureToken(32)
+	if token == "" {
+		return errors.New("failed to generate verification token")
+	}
+
+	_ = s.verifyRepo.DeleteByUserID(ctx, user.ID)
+	verification := &EmailVerificationToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(emailVerificationLifetime),
+	}
+	if err := s.verifyRepo.Create(ctx, verification); err != nil {
+		return err
+	}
+
+	go func(ctx context.Context, email, token string) {
+		ctx, span := otel.Tracer("account-service").Start(ctx, "SendVerificationEmail")
+		defer span.End()
+
+		if err := s.emailService.SendVerificationEmail(email, token); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			s.log.Error("Failed to send verification email", "error", err, "email", email)
+		} else {
+			s.log.Info("Verification email sent successfully", "email", email)
+		}
+	}(context.WithoutCancel(ctx), user.Email, token)
 
-	return claims.SignedString(GetSecretKey())
+	return nil
 }
 
 func (s *AccountService) ForgotPassword(ctx context.Context, email string) error {
@@ -220,33 +339,2986 @@ func (s *AccountService) UpdatePassword(ctx context.Context, req PasswordDto, us
 	return nil
 }
 
+// RequestEmailChange enregistre la nouvelle adresse sans l'appliquer : elle
+// reçoit un lien de confirmation et l'ancienne un avis avec un lien
+// d'annulation. Une demande remplace la précédente.
+func (s *AccountService) RequestEmailChange(ctx context.Context, userID uint, req ChangeEmailDto) error {
+	ctx, span := otel.Tracer("account-service").Start(ctx, "RequestEmailChange")
+	defer span.End()
+
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword)); err != nil {
+		return ErrInvalidCurrentPassword
+	}
+
+	newEmail := strings.TrimSpace(req.NewEmail)
+	if _, err := mail.ParseAddress(newEmail); err != nil || strings.ContainsAny(newEmail, "<> ") {
+		return fmt.Errorf("%w: invalid email address", ErrInvalidEmailChange)
+	}
+	if newEmail == user.Email {
+		return fmt.Errorf("%w: new address is the current one", ErrInvalidEmailChange)
+	}
+	if _, err := s.repo.GetByEmail(ctx, newEmail); err == nil {
+		return ErrEmailTaken
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	confirmToken := s.generateSecureToken(32)
+	cancelToken := s.generateSecureToken(32)
+	if confirmToken == "" || cancelToken == "" {
+		return errors.New("failed to generate email change tokens")
+	}
+
+	_ = s.changeRepo.DeleteByUserID(ctx, user.ID)
+	request := &EmailChangeRequest{
+		UserID:      user.ID,
+		NewEmail:    newEmail,
+		ConfirmHash: hashToken(confirmToken),
+		CancelHash:  hashToken(cancelToken),
+		ExpiresAt:   time.Now().Add(emailChangeLifetime),
+	}
+	if err := s.changeRepo.Create(ctx, request); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	go func(ctx context.Context, oldEmail, newEmail, confirmToken, cancelToken string) {
+		ctx, span := otel.Tracer("account-service").Start(ctx, "SendEmailChangeEmails")
+		defer span.End()
+
+		if err := s.emailService.SendEmailChangeConfirmation(newEmail, confirmToken); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			s.log.Error("Failed to send email change confirmation", "error", err, "email", newEmail)
+		}
+		if err := s.emailService.SendEmailChangeNotice(oldEmail, newEmail, cancelToken); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			s.log.Error("Failed to send email change notice", "error", err, "email", oldEmail)
+		}
+	}(context.WithoutCancel(ctx), user.Email, newEmail, confirmToken, cancelToken)
+
+	return nil
+}
+
+// ConfirmEmailChange applique le changement dont le lien de confirmation a été
+// ouvert. La nouvelle adresse est alors vérifiée.
+func (s *AccountService) ConfirmEmailChange(ctx context.Context, token string) error {
+	ctx, span := otel.Tracer("account-service").Start(ctx, "ConfirmEmailChange")
+	defer span.End()
+
+	request, err := s.changeRepo.GetByConfirmHash(ctx, hashToken(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("%w: invalid or expired link", ErrInvalidEmailChange)
+	}
+	if err != nil {
+		return err
+	}
+	if request.ExpiresAt.Before(time.Now()) {
+		_ = s.changeRepo.DeleteByUserID(ctx, request.UserID)
+		return fmt.Errorf("%w: invalid or expired link", ErrInvalidEmailChange)
+	}
+
+	err = s.repo.UpdateEmail(ctx, request.UserID, request.NewEmail, time.Now())
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		_ = s.changeRepo.DeleteByUserID(ctx, request.UserID)
+		return ErrEmailTaken
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	_ = s.changeRepo.DeleteByUserID(ctx, request.UserID)
+	// Un lien de vérification envoyé à l'ancienne adresse ne doit plus servir
+	_ = s.verifyRepo.DeleteByUserID(ctx, request.UserID)
+	s.log.Info("Email address changed", "user_id", request.UserID)
+	return nil
+}
+
+// CancelEmailChange abandonne le changement signalé à l'ancienne adresse.
+func (s *AccountService) CancelEmailChange(ctx context.Context, token string) error {
+	request, err := s.changeRepo.GetByCancelHash(ctx, hashToken(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("%w: invalid or expired link", ErrInvalidEmailChange)
+	}
+	if err != nil {
+		return err
+	}
+	s.log.Warn("Email change cancelled from the previous address", "user_id", request.UserID)
+	return s.changeRepo.DeleteByUserID(ctx, request.UserID)
+}
+
+// --- Session Service ---
+
+var ErrInvalidSession = errors.New("invalid or revoked session")
+
+const (
+	// Le jeton d'accès est court ; la session, prolongée à chaque
+	// rafraîchissement, dure tant que le client revient dans ce délai
+	accessTokenLifetime = 15 * time.Minute
+	sessionLifetime     = 30 * 24 * time.Hour
+	// LastSeenAt n'est réécrit qu'au-delà de cet intervalle, pas à chaque requête
+	sessionTouchInterval = time.Minute
+)
+
+type SessionService struct {
+	repo        SessionRepository
+	refreshRepo RefreshTokenRepository
+	tx          Transactor
+	keys        *KeyRing
+	log         *slog.Logger
+}
+
+func NewSessionService(repo SessionRepository, refreshRepo RefreshTokenRepository, tx Transactor, keys *KeyRing, log *slog.Logger) *SessionService {
+	return &SessionService{repo: repo, refreshRepo: refreshRepo, tx: tx, keys: keys, log: log}
+}
+
+// Create ouvre une session et renvoie son premier couple de jetons.
+func (s *SessionService) Create(ctx context.Context, userID uint, info SessionInfo) (*TokenPair, error) {
+	jti, err := newTokenID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	session := &Session{
+		UserID:     userID,
+		JTI:        jti,
+		UserAgent:  info.UserAgent,
+		IP:         info.IP,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(sessionLifetime),
+	}
+
+	var refresh string
+	err = s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.repo.Create(ctx, session); err != nil {
+			return err
+		}
+		refresh, err = s.issueRefreshToken(ctx, session.ID, session.ExpiresAt)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.tokenPair(userID, jti, refresh, now)
+}
+
+// Refresh consomme un jeton de rafraîchissement et en émet un nouveau avec un
+// jeton d'accès neuf. Présenter un jeton déjà consommé signale un vol
+// probable : la session entière, donc toute la famille de jetons, est révoquée.
+func (s *SessionService) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	stored, err := s.refreshRepo.GetByHash(ctx, hashToken(refreshToken))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidSession
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var session *Session
+	var jti, refresh string
+	reused := false
+	err = s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		rotated, err := s.refreshRepo.MarkRotated(ctx, stored.ID, now)
+		if err != nil {
+			return err
+		}
+		if !rotated {
+			reused = true
+			return nil
+		}
+		session, err = s.repo.GetByID(ctx, stored.SessionID)
+		if err != nil {
+			return err
+		}
+		if session.RevokedAt != nil || !session.ExpiresAt.After(now) || !stored.ExpiresAt.After(now) {
+			return ErrInvalidSession
+		}
+
+		if jti, err = newTokenID(); err != nil {
+			return err
+		}
+		expiresAt := now.Add(sessionLifetime)
+		if err := s.repo.Renew(ctx, session.ID, jti, expiresAt, now); err != nil {
+			return err
+		}
+		refresh, err = s.issueRefreshToken(ctx, session.ID, expiresAt)
+		return err
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidSession
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if reused {
+		s.log.Warn("Refresh token reused, revoking session", "session_id", stored.SessionID)
+		family, err := s.repo.GetByID(ctx, stored.SessionID)
+		if err == nil && family.RevokedAt == nil {
+			if err := s.repo.Revoke(ctx, family.UserID, family.ID, now); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, err
+			}
+		}
+		return nil, ErrInvalidSession
+	}
+	return s.tokenPair(session.UserID, jti, refresh, now)
+}
+
+func (s *SessionService) issueRefreshToken(ctx context.Context, sessionID uint, expiresAt time.Time) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	err := s.refreshRepo.Create(ctx, &RefreshToken{
+		SessionID: sessionID,
+		TokenHash: hashToken(token),
+		ExpiresAt: expiresAt,
+	})
+	return token, err
+}
+
+func (s *SessionService) tokenPair(userID uint, jti, refresh string, now time.Time) (*TokenPair, error) {
+	access, err := s.keys.Sign(jwt.MapClaims{
+		"sub": fmt.Sprintf("%d", userID),
+		"iss": "threadStocks",
+		"jti": jti,
+		"exp": now.Add(accessTokenLifetime).Unix(),
+		"iat": now.Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(accessTokenLifetime.Seconds()),
+	}, nil
+}
+
+// JWKS renvoie les clés publiques qui vérifient encore les jetons d'accès.
+func (s *SessionService) JWKS() JWKSet {
+	return s.keys.JWKS()
+}
+
+// hashToken empreinte un jeton opaque avant stockage : une fuite de la base
+// ne donne pas de jetons utilisables.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticate vérifie la signature du jeton puis l'état de sa session.
+func (s *SessionService) Authenticate(ctx context.Context, tokenString string) (*Session, error) {
+	token, err := jwt.Parse(tokenString, s.keys.Keyfunc)
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidSession
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrInvalidSession
+	}
+	jti, _ := claims["jti"].(string)
+	sub, _ := claims["sub"].(string)
+	if jti == "" {
+		return nil, ErrInvalidSession
+	}
+
+	session, err := s.repo.GetByJTI(ctx, jti)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidSession
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if session.RevokedAt != nil || !session.ExpiresAt.After(now) || sub != strconv.FormatUint(uint64(session.UserID), 10) {
+		return nil, ErrInvalidSession
+	}
+
+	if now.Sub(session.LastSeenAt) > sessionTouchInterval {
+		if err := s.repo.Touch(ctx, session.ID, now); err != nil {
+			s.log.Warn("Failed to update session last seen", "error", err, "session_id", session.ID)
+		}
+		session.LastSeenAt = now
+	}
+	return session, nil
+}
+
+// Logout révoque la session du jeton d'accès ou, s'il a expiré, celle du
+// jeton de rafraîchissement. Des jetons déjà invalides sont ignorés.
+func (s *SessionService) Logout(ctx context.Context, accessToken, refreshToken string) error {
+	var session *Session
+	if accessToken != "" {
+		authenticated, err := s.Authenticate(ctx, accessToken)
+		if err != nil && !errors.Is(err, ErrInvalidSession) {
+			return err
+		}
+		session = authenticated
+	}
+	if session == nil && refreshToken != "" {
+		stored, err := s.refreshRepo.GetByHash(ctx, hashToken(refreshToken))
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if session, err = s.repo.GetByID(ctx, stored.SessionID); err != nil {
+			return err
+		}
+	}
+	if session == nil || session.RevokedAt != nil {
+		return nil
+	}
+	err := s.repo.Revoke(ctx, session.UserID, session.ID, time.Now())
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (s *SessionService) GetSessions(ctx context.Context, userID uint, currentID uint) ([]Session, error) {
+	sessions, err := s.repo.GetActiveByUserID(ctx, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentID
+	}
+	return sessions, nil
+}
+
+func (s *SessionService) Revoke(ctx context.Context, userID uint, id uint) error {
+	return s.repo.Revoke(ctx, userID, id, time.Now())
+}
+
+// RevokeOthers révoque toutes les sessions de l'utilisateur sauf currentID.
+func (s *SessionService) RevokeOthers(ctx context.Context, userID uint, currentID uint) (int64, error) {
+	return s.repo.RevokeOthers(ctx, userID, currentID, time.Now())
+}
+
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
 // --- Thread Service ---
 
 type ThreadService struct {
-	repo ThreadRepository
-	log  *slog.Logger
+	repo         ThreadRepository
+	movementRepo StockMovementRepository
+	userRepo     UserRepository
+	shoppingRepo ShoppingListRepository
+	locationRepo LocationRepository
+	catalog      *CatalogService
+	tx           Transactor
+	emailService *EmailService
+	log          *slog.Logger
 }
 
-func NewThreadService(repo ThreadRepository, log *slog.Logger) *ThreadService {
-	return &ThreadService{repo: repo, log: log}
+func NewThreadService(repo ThreadRepository, movementRepo StockMovementRepository, userRepo UserRepository, shoppingRepo ShoppingListRepository, locationRepo LocationRepository, catalog *CatalogService, tx Transactor, emailService *EmailService, log *slog.Logger) *ThreadService {
+	return &ThreadService{repo: repo, movementRepo: movementRepo, userRepo: userRepo, shoppingRepo: shoppingRepo, locationRepo: locationRepo, catalog: catalog, tx: tx, emailService: emailService, log: log}
 }
 
-func (s *ThreadService) GetThreadsByUserID(ctx context.Context, userID uint) ([]Thread, error) {
-	return s.repo.GetByUserID(ctx, userID)
+const (
+	MovementCreate  = "create"
+	MovementUpdate  = "update"
+	MovementDelete  = "delete"
+	MovementRestore = "restore"
+)
+
+var (
+	ErrInvalidMovementReason = errors.New("invalid movement reason")
+	ErrInvalidQuantity       = errors.New("quantities cannot be negative")
+)
+
+var movementReasons = map[string]bool{
+	"purchase":        true,
+	"used_in_project": true,
+	"correction":      true,
+	"gift":            true,
 }
 
-func (s *ThreadService) CreateThread(ctx context.Context, thread *Thread) error {
-	return s.repo.Create(ctx, thread)
+func validateMovementReason(reason string) (string, error) {
+	if reason == "" {
+		return "correction", nil
+	}
+	if !movementReasons[reason] {
+		return "", ErrInvalidMovementReason
+	}
+	return reason, nil
 }
 
-func (s *ThreadService) UpdateThread(ctx context.Context, thread *Thread) error {
-	return s.repo.Update(ctx, thread)
+// validateQuantities refuse les quantités par format et le seuil de stock bas négatifs.
+func validateQuantities(thread *Thread) error {
+	if thread.Skeins < 0 || thread.Cards < 0 || thread.Spools < 0 || thread.PartialMetres < 0 {
+		return ErrInvalidQuantity
+	}
+	if thread.MinQuantity != nil && *thread.MinQuantity < 0 {
+		return ErrInvalidQuantity
+	}
+	return nil
 }
 
-func (s *ThreadService) DeleteThread(ctx context.Context, userID uint, id uint) error {
-	return s.repo.Delete(ctx, userID, id)
+// recordMovement est appelé après chaque écriture : il journalise la variation
+// de stock, réévalue l'alerte de stock bas du fil et la liste de courses.
+func (s *ThreadService) recordMovement(ctx context.Context, thread *Thread, event, reason string, delta int64) error {
+	if delta != 0 || event != MovementUpdate {
+		err := s.movementRepo.Create(ctx, &StockMovement{
+			UserID:   thread.UserID,
+			ThreadID: thread.ID,
+			Event:    event,
+			Reason:   reason,
+			Delta:    delta,
+			Balance:  thread.ThreadCount,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Un fil en corbeille n'a plus sa place sur la liste de courses
+	if event == MovementDelete {
+		return s.shoppingRepo.DeleteOpenAuto(ctx, thread.UserID, ThreadKey{Brand: thread.Brand, ThreadId: thread.ThreadId})
+	}
+	current, err := s.repo.GetByID(ctx, thread.ID)
+	if err != nil {
+		return err
+	}
+	if err := s.evaluateLowStock(ctx, thread.UserID, []Thread{*current}); err != nil {
+		return err
+	}
+	return s.syncShoppingList(ctx, current)
+}
+
+// syncShoppingList ajoute à la liste de courses un fil arrivé à zéro et retire
+// l'article ajouté automatiquement dès que le fil est de nouveau en stock.
+func (s *ThreadService) syncShoppingList(ctx context.Context, thread *Thread) error {
+	key := ThreadKey{Brand: thread.Brand, ThreadId: thread.ThreadId}
+	if thread.ThreadCount > 0 {
+		return s.shoppingRepo.DeleteOpenAuto(ctx, thread.UserID, key)
+	}
+
+	_, err := s.shoppingRepo.GetOpenByKey(ctx, thread.UserID, key)
+	if err == nil || !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	quantity := int64(1)
+	if thread.MinQuantity != nil && *thread.MinQuantity > quantity {
+		quantity = *thread.MinQuantity
+	}
+	return s.shoppingRepo.Create(ctx, &ShoppingListItem{
+		UserID:   thread.UserID,
+		Brand:    thread.Brand,
+		ThreadId: thread.ThreadId,
+		Quantity: quantity,
+		Source:   ShoppingSourceAuto,
+	})
 }
 
-func (s *ThreadService) DeleteMultiple(ctx context.Context, userID uint, ids []string) error {
-	return s.repo.DeleteMultiple(ctx, userID, ids)
-}
\ No newline at end of file
+// evaluateLowStock positionne le drapeau de stock bas des fils et prévient
+// l'utilisateur, une seule fois par fil tant qu'il n'est pas réapprovisionné.
+func (s *ThreadService) evaluateLowStock(ctx context.Context, userID uint, threads []Thread) error {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	var newlyLow []Thread
+	for _, t := range threads {
+		minQuantity := user.DefaultMinQuantity
+		if t.MinQuantity != nil {
+			minQuantity = *t.MinQuantity
+		}
+		low := minQuantity > 0 && t.ThreadCount < minQuantity
+
+		fields := map[string]any{}
+		if low != t.LowStock {
+			fields["low_stock"] = low
+		}
+		if low && t.LowStockNotifiedAt == nil {
+			fields["low_stock_notified_at"] = time.Now()
+			newlyLow = append(newlyLow, t)
+		}
+		if !low && t.LowStockNotifiedAt != nil {
+			fields["low_stock_notified_at"] = nil
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		if err := s.repo.Patch(ctx, userID, t.ID, fields); err != nil {
+			return err
+		}
+	}
+
+	if len(newlyLow) > 0 {
+		AfterCommit(ctx, func() {
+			go s.sendLowStockEmail(context.WithoutCancel(ctx), user.Email, newlyLow)
+		})
+	}
+	return nil
+}
+
+func (s *ThreadService) sendLowStockEmail(ctx context.Context, email string, threads []Thread) {
+	_, span := otel.Tracer("thread-service").Start(ctx, "SendLowStockEmail")
+	defer span.End()
+
+	if err := s.emailService.SendLowStockEmail(email, threads); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.Error("Failed to send low stock email", "error", err, "email", email)
+	} else {
+		s.log.Info("Low stock email sent successfully", "email", email, "threads", len(threads))
+	}
+}
+
+func (s *ThreadService) GetLowStock(ctx context.Context, userID uint) ([]Thread, error) {
+	threads, err := s.repo.GetLowStock(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if threads == nil {
+		threads = []Thread{}
+	}
+	return threads, nil
+}
+
+// SetDefaultMinQuantity change le seuil par défaut de l'utilisateur et
+// réévalue tous ses fils.
+func (s *ThreadService) SetDefaultMinQuantity(ctx context.Context, userID uint, quantity int64) error {
+	if quantity < 0 {
+		return errors.New("default_min_quantity cannot be negative")
+	}
+
+	return s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		// Updates ignore les valeurs nulles : 0 doit être écrit explicitement
+		if err := s.userRepo.UpdateFields(ctx, userID, map[string]any{"default_min_quantity": quantity}); err != nil {
+			return err
+		}
+
+		threads, err := s.repo.GetByUserID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		return s.evaluateLowStock(ctx, userID, threads)
+	})
+}
+
+// checkLocation vérifie que l'emplacement, s'il est renseigné, appartient à l'utilisateur.
+func (s *ThreadService) checkLocation(ctx context.Context, userID uint, locationID *uint) error {
+	if locationID == nil {
+		return nil
+	}
+	_, err := s.locationRepo.GetByID(ctx, userID, *locationID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidLocation
+	}
+	return err
+}
+
+// getOwnedThread renvoie le fil id s'il appartient à userID.
+func (s *ThreadService) getOwnedThread(ctx context.Context, userID uint, id uint) (*Thread, error) {
+	thread, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if thread.UserID != userID {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return thread, nil
+}
+
+func (s *ThreadService) GetThreadsByUserID(ctx context.Context, userID uint) ([]Thread, error) {
+	return s.repo.GetByUserID(ctx, userID)
+}
+
+const (
+	defaultThreadPageSize = 50
+	maxThreadPageSize     = 500
+)
+
+// ErrInvalidThreadQuery signale un tri ou un curseur invalide dans la liste des fils.
+var ErrInvalidThreadQuery = errors.New("invalid thread query")
+
+var threadSortColumns = map[string]bool{
+	"thread_id":    true,
+	"brand":        true,
+	"thread_count": true,
+	"updated_at":   true,
+}
+
+func (s *ThreadService) ListThreads(ctx context.Context, userID uint, query ThreadListQuery) (*ThreadPage, error) {
+	if query.Sort == "" {
+		query.Sort = "thread_id"
+	}
+	if !threadSortColumns[query.Sort] {
+		return nil, fmt.Errorf("%w: invalid sort field", ErrInvalidThreadQuery)
+	}
+	// Un curseur n'a de sens que pour le tri qui l'a produit ; les curseurs
+	// sans tri enregistré sont vérifiés à la lecture de leur valeur
+	if query.Cursor != nil && query.Cursor.Sort != "" && (query.Cursor.Sort != query.Sort || query.Cursor.Desc != query.Desc) {
+		return nil, fmt.Errorf("%w: cursor does not match the requested sort", ErrInvalidThreadQuery)
+	}
+	if query.Limit <= 0 {
+		query.Limit = defaultThreadPageSize
+	}
+	if query.Limit > maxThreadPageSize {
+		query.Limit = maxThreadPageSize
+	}
+
+	if query.LocationID != nil && !query.Unlocated {
+		locations, err := s.locationRepo.GetByUserID(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if query.LocationIDs = locationSubtree(locations, *query.LocationID); len(query.LocationIDs) == 0 {
+			return nil, ErrInvalidLocation
+		}
+	}
+
+	// On demande un élément de plus pour savoir s'il reste une page
+	limit := query.Limit
+	query.Limit++
+	threads, total, err := s.repo.List(ctx, userID, query)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &ThreadPage{Items: threads, Total: total}
+	if len(threads) > limit {
+		page.Items = threads[:limit]
+		page.NextCursor = encodeThreadCursor(query.Sort, query.Desc, page.Items[limit-1])
+	}
+	if page.Items == nil {
+		page.Items = []Thread{}
+	}
+	return page, nil
+}
+
+func encodeThreadCursor(sort string, desc bool, last Thread) string {
+	cursor := ThreadCursor{Sort: sort, Desc: desc, ID: last.ID}
+	switch sort {
+	case "brand":
+		cursor.Value = last.Brand
+	case "thread_count":
+		cursor.Value = strconv.FormatInt(last.ThreadCount, 10)
+	case "updated_at":
+		cursor.Value = last.UpdatedAt.Format(time.RFC3339Nano)
+	default:
+		cursor.Value = last.ThreadId
+	}
+
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func DecodeThreadCursor(encoded string) (*ThreadCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid cursor", ErrInvalidThreadQuery)
+	}
+	var cursor ThreadCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return nil, fmt.Errorf("%w: invalid cursor", ErrInvalidThreadQuery)
+	}
+	return &cursor, nil
+}
+
+func (s *ThreadService) CreateThread(ctx context.Context, thread *Thread, reason string) error {
+	_, err := s.createThread(ctx, thread, reason)
+	return err
+}
+
+// createThread renvoie true si le fil a été restauré depuis la corbeille.
+func (s *ThreadService) createThread(ctx context.Context, thread *Thread, reason string) (bool, error) {
+	reason, err := validateMovementReason(reason)
+	if err != nil {
+		return false, err
+	}
+
+	if err := s.catalog.ResolveThread(ctx, thread); err != nil {
+		return false, err
+	}
+	if err := validateQuantities(thread); err != nil {
+		return false, err
+	}
+	if err := s.checkLocation(ctx, thread.UserID, thread.LocationID); err != nil {
+		return false, err
+	}
+
+	var restored bool
+	err = s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		if restored, err = s.repo.Create(ctx, thread); err != nil {
+			return err
+		}
+		event := MovementCreate
+		if restored {
+			event = MovementRestore
+		}
+		return s.recordMovement(ctx, thread, event, reason, thread.ThreadCount)
+	})
+	return restored, err
+}
+
+// AddStock ajoute delta échevettes au fil, en le créant s'il n'existe pas.
+func (s *ThreadService) AddStock(ctx context.Context, userID uint, key ThreadKey, delta int64, reason string) (*Thread, error) {
+	reason, err := validateMovementReason(reason)
+	if err != nil {
+		return nil, err
+	}
+
+	var thread *Thread
+	err = s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		existing, err := s.repo.GetByKeys(ctx, userID, []ThreadKey{key})
+		if err != nil {
+			return err
+		}
+		if len(existing) == 0 {
+			thread = &Thread{UserID: userID, Brand: key.Brand, ThreadId: key.ThreadId, ThreadCount: delta, Skeins: delta, IsCustom: true}
+			_, err := s.createThread(ctx, thread, reason)
+			return err
+		}
+
+		thread = &existing[0]
+		// Incrément côté base pour ne pas écraser une écriture concurrente ;
+		// un achat arrive en échevettes
+		fields := map[string]any{
+			"thread_count": gorm.Expr("thread_count + ?", delta),
+			"skeins":       gorm.Expr("skeins + ?", delta),
+		}
+		if err := s.repo.Patch(ctx, userID, thread.ID, fields); err != nil {
+			return err
+		}
+		if thread, err = s.repo.GetByID(ctx, thread.ID); err != nil {
+			return err
+		}
+		return s.recordMovement(ctx, thread, MovementUpdate, reason, delta)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return thread, nil
+}
+
+func (s *ThreadService) UpdateThread(ctx context.Context, thread *Thread, reason string) error {
+	reason, err := validateMovementReason(reason)
+	if err != nil {
+		return err
+	}
+
+	if err := s.catalog.ResolveThread(ctx, thread); err != nil {
+		return err
+	}
+	if err := validateQuantities(thread); err != nil {
+		return err
+	}
+	if err := s.checkLocation(ctx, thread.UserID, thread.LocationID); err != nil {
+		return err
+	}
+
+	return s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		previous, err := s.getOwnedThread(ctx, thread.UserID, thread.ID)
+		if err != nil {
+			return err
+		}
+		if err := s.repo.Update(ctx, thread); err != nil {
+			return err
+		}
+		return s.recordMovement(ctx, thread, MovementUpdate, reason, thread.ThreadCount-previous.ThreadCount)
+	})
+}
+
+// PatchThread applique un document JSON Merge Patch (RFC 7396) sur un fil.
+func (s *ThreadService) PatchThread(ctx context.Context, userID uint, id uint, patch map[string]json.RawMessage, reason string) (*Thread, error) {
+	reason, err := validateMovementReason(reason)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]any, len(patch))
+	quantities := map[string]int64{}
+	legacyFlags := map[string]bool{}
+	for key, raw := range patch {
+		isNull := string(raw) == "null"
+		switch key {
+		case "thread_id", "brand":
+			var v string
+			if err := json.Unmarshal(raw, &v); err != nil {
+				return nil, fmt.Errorf("invalid value for %s", key)
+			}
+			// "null" se décode en chaîne vide : ni la marque ni le numéro ne peuvent être retirés
+			v = strings.TrimSpace(v)
+			if v == "" {
+				return nil, fmt.Errorf("%s cannot be removed", key)
+			}
+			fields[key] = v
+		case "is_custom":
+			var v bool
+			if !isNull {
+				if err := json.Unmarshal(raw, &v); err != nil {
+					return nil, fmt.Errorf("invalid value for %s", key)
+				}
+			}
+			fields[key] = v
+		case "is_e", "is_c", "is_s":
+			var v bool
+			if !isNull {
+				if err := json.Unmarshal(raw, &v); err != nil {
+					return nil, fmt.Errorf("invalid value for %s", key)
+				}
+			}
+			legacyFlags[key] = v
+		case "location_id":
+			if isNull {
+				fields[key] = nil
+				continue
+			}
+			var v uint
+			if err := json.Unmarshal(raw, &v); err != nil {
+				return nil, fmt.Errorf("invalid value for %s", key)
+			}
+			if err := s.checkLocation(ctx, userID, &v); err != nil {
+				return nil, err
+			}
+			fields[key] = v
+		case "min_quantity":
+			if isNull {
+				fields[key] = nil
+				continue
+			}
+			var v int64
+			if err := json.Unmarshal(raw, &v); err != nil {
+				return nil, fmt.Errorf("invalid value for %s", key)
+			}
+			if v < 0 {
+				return nil, ErrInvalidQuantity
+			}
+			fields[key] = v
+		case "thread_count", "skeins", "cards", "spools":
+			var v int64
+			if !isNull {
+				if err := json.Unmarshal(raw, &v); err != nil {
+					return nil, fmt.Errorf("invalid value for %s", key)
+				}
+			}
+			if v < 0 {
+				return nil, ErrInvalidQuantity
+			}
+			quantities[key] = v
+		case "partial_metres":
+			var v float64
+			if !isNull {
+				if err := json.Unmarshal(raw, &v); err != nil {
+					return nil, fmt.Errorf("invalid value for %s", key)
+				}
+			}
+			if v < 0 {
+				return nil, ErrInvalidQuantity
+			}
+			fields[key] = v
+		default:
+			return nil, fmt.Errorf("unknown field %s", key)
+		}
+	}
+
+	var thread *Thread
+	err = s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		previous, err := s.getOwnedThread(ctx, userID, id)
+		if err != nil {
+			return err
+		}
+		if err := s.resolvePatchedIdentity(ctx, previous, fields); err != nil {
+			return err
+		}
+		patchQuantities(previous, quantities, legacyFlags, fields)
+		if len(fields) > 0 {
+			if err := s.repo.Patch(ctx, userID, id, fields); err != nil {
+				return err
+			}
+		}
+		if thread, err = s.repo.GetByID(ctx, id); err != nil {
+			return err
+		}
+		return s.recordMovement(ctx, thread, MovementUpdate, reason, thread.ThreadCount-previous.ThreadCount)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return thread, nil
+}
+
+// patchQuantities traduit les quantités du patch en colonnes. Les formats
+// explicites priment ; à défaut, thread_count et les anciens drapeaux
+// is_e/is_c/is_s sont interprétés comme le ferait un ancien client.
+func patchQuantities(previous *Thread, quantities map[string]int64, legacyFlags map[string]bool, fields map[string]any) {
+	if len(quantities) == 0 && len(legacyFlags) == 0 {
+		return
+	}
+
+	merged := *previous
+	_, skeins := quantities["skeins"]
+	_, cards := quantities["cards"]
+	_, spools := quantities["spools"]
+	switch {
+	case skeins || cards || spools:
+		for key, dest := range map[string]*int64{"skeins": &merged.Skeins, "cards": &merged.Cards, "spools": &merged.Spools} {
+			if v, ok := quantities[key]; ok {
+				*dest = v
+			}
+		}
+		merged.ThreadCount = merged.Skeins + merged.Cards + merged.Spools
+	case len(legacyFlags) > 0:
+		// Les drapeaux désignent le format de tout le stock
+		count, ok := quantities["thread_count"]
+		if !ok {
+			count = previous.ThreadCount
+		}
+		isE, isC, isS := previous.Skeins > 0, previous.Cards > 0, previous.Spools > 0
+		for key, dest := range map[string]*bool{"is_e": &isE, "is_c": &isC, "is_s": &isS} {
+			if v, ok := legacyFlags[key]; ok {
+				*dest = v
+			}
+		}
+		merged.SetLegacyCount(count, isE, isC, isS)
+	default:
+		merged.SetTotal(quantities["thread_count"])
+	}
+
+	fields["thread_count"] = merged.ThreadCount
+	fields["skeins"] = merged.Skeins
+	fields["cards"] = merged.Cards
+	fields["spools"] = merged.Spools
+}
+
+// resolvePatchedIdentity revalide la référence catalogue quand le patch touche
+// à la marque, au numéro ou au statut personnalisé.
+func (s *ThreadService) resolvePatchedIdentity(ctx context.Context, previous *Thread, fields map[string]any) error {
+	_, brand := fields["brand"]
+	_, threadId := fields["thread_id"]
+	_, custom := fields["is_custom"]
+	if !brand && !threadId && !custom {
+		return nil
+	}
+
+	merged := *previous
+	if v, ok := fields["brand"].(string); ok {
+		merged.Brand = v
+	}
+	if v, ok := fields["thread_id"].(string); ok {
+		merged.ThreadId = v
+	}
+	if v, ok := fields["is_custom"].(bool); ok {
+		merged.IsCustom = v
+	}
+	if err := s.catalog.ResolveThread(ctx, &merged); err != nil {
+		return err
+	}
+
+	fields["brand"] = merged.Brand
+	fields["thread_id"] = merged.ThreadId
+	fields["is_custom"] = merged.IsCustom
+	fields["catalog_color_id"] = merged.CatalogColorID
+	return nil
+}
+
+func (s *ThreadService) DeleteThread(ctx context.Context, userID uint, id uint, reason string) error {
+	reason, err := validateMovementReason(reason)
+	if err != nil {
+		return err
+	}
+
+	return s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		thread, err := s.getOwnedThread(ctx, userID, id)
+		if err != nil {
+			return err
+		}
+		if err := s.repo.Delete(ctx, userID, id); err != nil {
+			return err
+		}
+		delta := -thread.ThreadCount
+		thread.ThreadCount = 0
+		return s.recordMovement(ctx, thread, MovementDelete, reason, delta)
+	})
+}
+
+func (s *ThreadService) DeleteMultiple(ctx context.Context, userID uint, keys []ThreadKey, reason string) error {
+	reason, err := validateMovementReason(reason)
+	if err != nil {
+		return err
+	}
+
+	return s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		threads, err := s.repo.GetByKeys(ctx, userID, keys)
+		if err != nil {
+			return err
+		}
+		if err := s.repo.DeleteMultiple(ctx, userID, keys); err != nil {
+			return err
+		}
+		for i := range threads {
+			delta := -threads[i].ThreadCount
+			threads[i].ThreadCount = 0
+			if err := s.recordMovement(ctx, &threads[i], MovementDelete, reason, delta); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetMovements renvoie l'historique d'un fil avec la date du dernier achat
+// et la consommation moyenne par mois sur les 90 derniers jours.
+func (s *ThreadService) GetMovements(ctx context.Context, userID uint, id uint) (*StockMovementHistory, error) {
+	if _, err := s.getOwnedThread(ctx, userID, id); err != nil {
+		return nil, err
+	}
+
+	movements, err := s.movementRepo.GetByThreadID(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	history := &StockMovementHistory{Movements: movements}
+	if history.Movements == nil {
+		history.Movements = []StockMovement{}
+	}
+
+	since := time.Now().AddDate(0, 0, -90)
+	var used int64
+	for _, m := range movements {
+		if m.Reason == "purchase" && m.Delta > 0 && history.LastPurchaseAt == nil {
+			createdAt := m.CreatedAt
+			history.LastPurchaseAt = &createdAt
+		}
+		if m.Reason == "used_in_project" && m.Delta < 0 && m.CreatedAt.After(since) {
+			used -= m.Delta
+		}
+	}
+	history.UsedPerMonth = float64(used) / 3
+
+	return history, nil
+}
+
+func (s *ThreadService) GetTrash(ctx context.Context, userID uint) ([]Thread, error) {
+	threads, err := s.repo.GetTrash(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if threads == nil {
+		threads = []Thread{}
+	}
+	return threads, nil
+}
+
+func (s *ThreadService) RestoreThread(ctx context.Context, userID uint, id uint, reason string) (*Thread, error) {
+	reason, err := validateMovementReason(reason)
+	if err != nil {
+		return nil, err
+	}
+
+	var thread *Thread
+	err = s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		if thread, err = s.repo.GetTrashedByID(ctx, userID, id); err != nil {
+			return err
+		}
+		if err := s.repo.Restore(ctx, userID, id); err != nil {
+			return err
+		}
+		thread.DeletedAt = gorm.DeletedAt{}
+		return s.recordMovement(ctx, thread, MovementRestore, reason, thread.ThreadCount)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return thread, nil
+}
+
+// PurgeThread supprime définitivement un fil de la corbeille, avec son historique.
+func (s *ThreadService) PurgeThread(ctx context.Context, userID uint, id uint) error {
+	return s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		if _, err := s.repo.GetTrashedByID(ctx, userID, id); err != nil {
+			return err
+		}
+		return s.purge(ctx, []uint{id})
+	})
+}
+
+func (s *ThreadService) purge(ctx context.Context, ids []uint) error {
+	if err := s.movementRepo.DeleteByThreadIDs(ctx, ids); err != nil {
+		return err
+	}
+	if err := s.shoppingRepo.DeleteOpenAutoByThreadIDs(ctx, ids); err != nil {
+		return err
+	}
+	return s.repo.Purge(ctx, ids)
+}
+
+// PurgeExpiredTrash supprime définitivement les fils en corbeille depuis plus
+// de retention et renvoie leur nombre.
+func (s *ThreadService) PurgeExpiredTrash(ctx context.Context, retention time.Duration) (int, error) {
+	var purged int
+	err := s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		ids, err := s.repo.GetTrashedBefore(ctx, time.Now().Add(-retention))
+		if err != nil {
+			return err
+		}
+		purged = len(ids)
+		return s.purge(ctx, ids)
+	})
+	return purged, err
+}
+
+// RunTrashRetention purge la corbeille à intervalle régulier jusqu'à l'annulation de ctx.
+func (s *ThreadService) RunTrashRetention(ctx context.Context, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := s.PurgeExpiredTrash(ctx, retention)
+		if err != nil {
+			s.log.Error("Failed to purge expired trash", "error", err)
+		} else if purged > 0 {
+			s.log.Info("Purged expired trashed threads", "count", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// GetTrashRetention lit TRASH_RETENTION_DAYS (30 jours par défaut, 0 pour désactiver).
+func GetTrashRetention() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS"))
+	if err != nil || days < 0 {
+		days = 30
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+const exportBatchSize = 500
+
+var errDryRun = errors.New("dry run")
+
+// ImportCSV importe un inventaire CSV. Chaque ligne est traitée dans un
+// savepoint : une ligne invalide est rapportée sans bloquer les autres. Un fil
+// déjà présent est mis à jour, un fil en corbeille est restauré. En dry-run,
+// tout est exécuté puis annulé pour que le rapport reflète le résultat réel.
+func (s *ThreadService) ImportCSV(ctx context.Context, userID uint, r io.Reader, mapping ThreadCSVMapping, dryRun bool, reason string) (*ImportReport, error) {
+	if _, err := validateMovementReason(reason); err != nil {
+		return nil, err
+	}
+
+	rows, err := newThreadCSVReader(r, mapping)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ImportReport{DryRun: dryRun, Rows: []ImportRowResult{}}
+	err = s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		for {
+			line, dto, err := rows.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			result := ImportRowResult{Line: line}
+			if err == nil {
+				result.Status, err = s.importRow(ctx, userID, &dto, rows.Provides, reason)
+			}
+			if err != nil {
+				result.Status = "failed"
+				result.Error = err.Error()
+				report.Failed++
+			} else {
+				result.Thread = &dto
+			}
+			report.Rows = append(report.Rows, result)
+		}
+
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		return nil, err
+	}
+
+	for _, row := range report.Rows {
+		switch row.Status {
+		case "created":
+			report.Created++
+		case "updated":
+			report.Updated++
+		case "restored":
+			report.Restored++
+		}
+	}
+	return report, nil
+}
+
+// importRow crée ou met à jour le fil de la ligne. provides indique les
+// colonnes présentes dans le fichier ; un fil existant garde ses valeurs pour
+// les autres.
+func (s *ThreadService) importRow(ctx context.Context, userID uint, dto *ThreadDto, provides func(string) bool, reason string) (string, error) {
+	thread := NewThreadFromDto(userID, *dto)
+
+	status := ""
+	err := s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.catalog.ResolveThread(ctx, &thread); err != nil {
+			return err
+		}
+		existing, err := s.repo.GetByKeys(ctx, userID, []ThreadKey{{Brand: thread.Brand, ThreadId: thread.ThreadId}})
+		if err != nil {
+			return err
+		}
+
+		if len(existing) > 0 {
+			keepImportedColumns(&thread, existing[0], provides)
+			thread.ID = existing[0].ID
+			status = "updated"
+			return s.UpdateThread(ctx, &thread, reason)
+		}
+
+		restored, err := s.createThread(ctx, &thread, reason)
+		status = "created"
+		if restored {
+			status = "restored"
+		}
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// On renvoie la forme normalisée (marque et numéro du catalogue)
+	dto.Brand = thread.Brand
+	dto.ThreadId = thread.ThreadId
+	dto.IsCustom = thread.IsCustom
+	dto.MinQuantity = thread.MinQuantity
+	dto.LocationID = thread.LocationID
+	dto.SetQuantities(thread)
+	return status, nil
+}
+
+// keepImportedColumns recopie depuis le fil existant les champs sans colonne
+// dans le fichier, pour que la mise à jour complète ne les efface pas.
+func keepImportedColumns(thread *Thread, existing Thread, provides func(string) bool) {
+	if !provides("min_quantity") {
+		thread.MinQuantity = existing.MinQuantity
+	}
+	if !provides("location_id") {
+		thread.LocationID = existing.LocationID
+	}
+	if !provides("partial_metres") {
+		thread.PartialMetres = existing.PartialMetres
+	}
+
+	formats := map[string][2]*int64{
+		"skeins": {&thread.Skeins, &existing.Skeins},
+		"cards":  {&thread.Cards, &existing.Cards},
+		"spools": {&thread.Spools, &existing.Spools},
+	}
+	anyFormat, anyCount := false, provides("thread_count")
+	for field := range formats {
+		anyFormat = anyFormat || provides(field)
+	}
+	for _, field := range threadCSVLegacyColumns {
+		anyCount = anyCount || provides(field)
+	}
+	switch {
+	case anyFormat:
+		// Seuls les formats présents dans le fichier changent
+		for field, v := range formats {
+			if !provides(field) {
+				*v[0] = *v[1]
+			}
+		}
+		thread.ThreadCount = thread.Skeins + thread.Cards + thread.Spools
+	case !anyCount:
+		thread.Skeins, thread.Cards, thread.Spools = existing.Skeins, existing.Cards, existing.Spools
+		thread.ThreadCount = existing.ThreadCount
+	}
+}
+
+// ExportCSV écrit l'inventaire complet avec les colonnes de ThreadDto.
+func (s *ThreadService) ExportCSV(ctx context.Context, userID uint, w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(threadCSVColumns); err != nil {
+		return err
+	}
+
+	err := s.repo.StreamByUserID(ctx, userID, exportBatchSize, func(threads []Thread) error {
+		for _, t := range threads {
+			if err := writer.Write(threadCSVRecord(t)); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	})
+	if err != nil {
+		return err
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+const maxBatchOperations = 1000
+
+var (
+	ErrBatchTooLarge = fmt.Errorf("a batch cannot exceed %d operations", maxBatchOperations)
+	errBatchRollback = errors.New("batch rolled back")
+)
+
+// Batch exécute un lot d'opérations dans une seule transaction. En mode
+// atomique, le premier échec annule tout le lot ; sinon chaque opération
+// est isolée dans un savepoint et le lot est validé avec les réussites.
+func (s *ThreadService) Batch(ctx context.Context, userID uint, req BatchRequest) (*BatchResult, error) {
+	if len(req.Operations) > maxBatchOperations {
+		return nil, ErrBatchTooLarge
+	}
+	if _, err := validateMovementReason(req.Reason); err != nil {
+		return nil, err
+	}
+
+	result := &BatchResult{Atomic: req.Atomic == nil || *req.Atomic}
+	result.Results = make([]BatchOperationResult, len(req.Operations))
+	for i, op := range req.Operations {
+		result.Results[i] = BatchOperationResult{Index: i, Op: op.Op, Status: "skipped"}
+	}
+
+	err := s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		failed := false
+		for i, op := range req.Operations {
+			thread, err := s.batchOperation(ctx, userID, op, req.Reason)
+			if err != nil {
+				result.Results[i].Status = "failed"
+				result.Results[i].Error = err.Error()
+				failed = true
+				if result.Atomic {
+					break
+				}
+				continue
+			}
+			result.Results[i].Status = "ok"
+			result.Results[i].Thread = thread
+		}
+
+		if failed && result.Atomic {
+			return errBatchRollback
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errBatchRollback) {
+		return nil, err
+	}
+
+	result.Committed = err == nil
+	if !result.Committed {
+		for i := range result.Results {
+			if result.Results[i].Status == "ok" {
+				result.Results[i].Status = "rolled_back"
+				result.Results[i].Thread = nil
+			}
+		}
+	}
+	return result, nil
+}
+
+func (s *ThreadService) batchOperation(ctx context.Context, userID uint, op BatchOperation, reason string) (*Thread, error) {
+	thread := NewThreadFromDto(userID, op.Thread)
+
+	// Savepoint : un échec n'invalide pas la transaction englobante
+	err := s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		switch op.Op {
+		case "create":
+			return s.CreateThread(ctx, &thread, reason)
+		case "update":
+			id, err := s.batchTargetID(ctx, userID, op)
+			if err != nil {
+				return err
+			}
+			thread.ID = id
+			return s.UpdateThread(ctx, &thread, reason)
+		case "delete":
+			id, err := s.batchTargetID(ctx, userID, op)
+			if err != nil {
+				return err
+			}
+			thread.ID = id
+			return s.DeleteThread(ctx, userID, id, reason)
+		default:
+			return fmt.Errorf("unknown operation %q", op.Op)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if op.Op == "delete" {
+		return nil, nil
+	}
+	return &thread, nil
+}
+
+// batchTargetID retrouve le fil visé par son id ou par (brand, thread_id).
+func (s *ThreadService) batchTargetID(ctx context.Context, userID uint, op BatchOperation) (uint, error) {
+	if op.ID != 0 {
+		return op.ID, nil
+	}
+
+	key := Thread{Brand: op.Thread.Brand, ThreadId: op.Thread.ThreadId, IsCustom: true}
+	if err := s.catalog.ResolveThread(ctx, &key); err != nil {
+		return 0, err
+	}
+	threads, err := s.repo.GetByKeys(ctx, userID, []ThreadKey{{Brand: key.Brand, ThreadId: key.ThreadId}})
+	if err != nil {
+		return 0, err
+	}
+	if len(threads) == 0 {
+		return 0, gorm.ErrRecordNotFound
+	}
+	return threads[0].ID, nil
+}
+
+const defaultSimilarThreads = 10
+
+var ErrThreadWithoutColor = errors.New("thread is not linked to a catalogue colour")
+
+// FindSimilar classe les fils de l'utilisateur par distance perceptuelle (ΔE00)
+// à la couleur donnée. Les fils sans coloris catalogue sont ignorés.
+func (s *ThreadService) FindSimilar(ctx context.Context, userID uint, target Lab, excludeID uint, inStockOnly bool, limit int) ([]SimilarThread, error) {
+	if limit <= 0 {
+		limit = defaultSimilarThreads
+	}
+
+	threads, err := s.repo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	similar := []SimilarThread{}
+	for _, t := range threads {
+		if t.ID == excludeID || t.CatalogColor == nil {
+			continue
+		}
+		if inStockOnly && t.ThreadCount <= 0 {
+			continue
+		}
+		c := t.CatalogColor
+		similar = append(similar, SimilarThread{
+			Thread: t,
+			DeltaE: CIEDE2000(target, RGBToLab(c.R, c.G, c.B)),
+		})
+	}
+
+	sort.Slice(similar, func(i, j int) bool { return similar[i].DeltaE < similar[j].DeltaE })
+	if len(similar) > limit {
+		similar = similar[:limit]
+	}
+	return similar, nil
+}
+
+func (s *ThreadService) FindSimilarToHex(ctx context.Context, userID uint, hex string, inStockOnly bool, limit int) ([]SimilarThread, error) {
+	r, g, b, err := ParseHexColor(hex)
+	if err != nil {
+		return nil, err
+	}
+	return s.FindSimilar(ctx, userID, RGBToLab(r, g, b), 0, inStockOnly, limit)
+}
+
+func (s *ThreadService) FindSimilarToThread(ctx context.Context, userID uint, id uint, inStockOnly bool, limit int) ([]SimilarThread, error) {
+	thread, err := s.getOwnedThread(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if thread.CatalogColorID == nil {
+		return nil, ErrThreadWithoutColor
+	}
+	color, err := s.catalog.repo.GetColorByID(ctx, *thread.CatalogColorID)
+	if err != nil {
+		return nil, err
+	}
+	return s.FindSimilar(ctx, userID, RGBToLab(color.R, color.G, color.B), thread.ID, inStockOnly, limit)
+}
+
+// --- Catalog Service ---
+
+type CatalogService struct {
+	repo CatalogRepository
+	log  *slog.Logger
+}
+
+func NewCatalogService(repo CatalogRepository, log *slog.Logger) *CatalogService {
+	return &CatalogService{repo: repo, log: log}
+}
+
+func (s *CatalogService) GetBrands(ctx context.Context) ([]Brand, error) {
+	return s.repo.GetBrands(ctx)
+}
+
+func (s *CatalogService) GetBrand(ctx context.Context, brand string) (*Brand, error) {
+	return s.repo.GetBrandByCode(ctx, NormalizeBrandCode(brand))
+}
+
+func (s *CatalogService) GetColors(ctx context.Context, brand string, search string, includeDiscontinued bool) ([]CatalogColor, error) {
+	b, err := s.GetBrand(ctx, brand)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetColors(ctx, b.ID, strings.TrimSpace(search), includeDiscontinued)
+}
+
+func (s *CatalogService) GetColor(ctx context.Context, brand string, number string) (*CatalogColor, error) {
+	b, err := s.GetBrand(ctx, brand)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetColor(ctx, b.ID, NormalizeColorNumber(number))
+}
+
+// defaultSkeinLength est la longueur d'une échevette de coton mouliné standard, en mètres.
+const defaultSkeinLength = 8.0
+
+// SkeinLength renvoie la longueur d'une échevette de la marque, ou la longueur
+// standard si la marque est hors catalogue.
+func (s *CatalogService) SkeinLength(ctx context.Context, brand string) float64 {
+	b, err := s.GetBrand(ctx, brand)
+	if err != nil || b.SkeinLength <= 0 {
+		return defaultSkeinLength
+	}
+	return b.SkeinLength
+}
+
+// defaultSkeinStrands est le nombre de brins d'un coton mouliné standard.
+const defaultSkeinStrands = 6
+
+// Skein décrit l'échevette de la marque, ou une échevette standard si la
+// marque est hors catalogue.
+func (s *CatalogService) Skein(ctx context.Context, brand string) skein.Skein {
+	sk := skein.Skein{Length: defaultSkeinLength, Strands: defaultSkeinStrands}
+	b, err := s.GetBrand(ctx, brand)
+	if err != nil {
+		return sk
+	}
+	if b.SkeinLength > 0 {
+		sk.Length = b.SkeinLength
+	}
+	if b.Strands > 0 {
+		sk.Strands = b.Strands
+	}
+	return sk
+}
+
+// ResolveThread normalise la marque et le numéro d'un fil et le relie au
+// catalogue. Un coloris ou une marque hors catalogue est marqué personnalisé :
+// le catalogue embarqué n'est pas exhaustif.
+func (s *CatalogService) ResolveThread(ctx context.Context, thread *Thread) error {
+	thread.Brand = strings.TrimSpace(thread.Brand)
+	thread.ThreadId = strings.TrimSpace(thread.ThreadId)
+	thread.CatalogColorID = nil
+	thread.CatalogColor = nil
+
+	brand, err := s.GetBrand(ctx, thread.Brand)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		thread.IsCustom = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	thread.Brand = brand.Name
+
+	color, err := s.repo.GetColor(ctx, brand.ID, NormalizeColorNumber(thread.ThreadId))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		thread.IsCustom = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	thread.ThreadId = color.Number
+	thread.IsCustom = false
+	thread.CatalogColorID = &color.ID
+	return nil
+}
+
+// --- Project Service ---
+
+const (
+	UnitSkein = "skein"
+	UnitMetre = "metre"
+)
+
+type ProjectService struct {
+	repo       ProjectRepository
+	threadRepo ThreadRepository
+	catalog    *CatalogService
+	tx         Transactor
+	log        *slog.Logger
+}
+
+func NewProjectService(repo ProjectRepository, threadRepo ThreadRepository, catalog *CatalogService, tx Transactor, log *slog.Logger) *ProjectService {
+	return &ProjectService{repo: repo, threadRepo: threadRepo, catalog: catalog, tx: tx, log: log}
+}
+
+func (s *ProjectService) GetProjects(ctx context.Context, userID uint) ([]Project, error) {
+	projects, err := s.repo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if projects == nil {
+		projects = []Project{}
+	}
+	return projects, nil
+}
+
+func (s *ProjectService) GetProject(ctx context.Context, userID uint, id uint) (*Project, error) {
+	return s.repo.GetByID(ctx, userID, id)
+}
+
+func (s *ProjectService) buildProject(ctx context.Context, userID uint, dto ProjectDto) (*Project, error) {
+	if strings.TrimSpace(dto.Name) == "" {
+		return nil, errors.New("name is required")
+	}
+
+	project := &Project{
+		UserID:       userID,
+		Name:         strings.TrimSpace(dto.Name),
+		Description:  dto.Description,
+		Requirements: make([]ProjectRequirement, 0, len(dto.Requirements)),
+	}
+	for i, req := range dto.Requirements {
+		if req.Quantity <= 0 {
+			return nil, fmt.Errorf("requirement %d: quantity must be positive", i)
+		}
+		unit := req.Unit
+		if unit == "" {
+			unit = UnitSkein
+		}
+		if unit != UnitSkein && unit != UnitMetre {
+			return nil, fmt.Errorf("requirement %d: unit must be %q or %q", i, UnitSkein, UnitMetre)
+		}
+
+		// On normalise comme pour un fil, sans refuser les coloris hors catalogue
+		key := Thread{Brand: req.Brand, ThreadId: req.ThreadId, IsCustom: true}
+		if err := s.catalog.ResolveThread(ctx, &key); err != nil {
+			return nil, err
+		}
+		if key.ThreadId == "" {
+			return nil, fmt.Errorf("requirement %d: thread_id is required", i)
+		}
+
+		project.Requirements = append(project.Requirements, ProjectRequirement{
+			Brand:    key.Brand,
+			ThreadId: key.ThreadId,
+			Quantity: req.Quantity,
+			Unit:     unit,
+		})
+	}
+	return project, nil
+}
+
+func (s *ProjectService) CreateProject(ctx context.Context, userID uint, dto ProjectDto) (*Project, error) {
+	project, err := s.buildProject(ctx, userID, dto)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.Create(ctx, project); err != nil {
+		return nil, err
+	}
+	return project, nil
+}
+
+func (s *ProjectService) UpdateProject(ctx context.Context, userID uint, id uint, dto ProjectDto) (*Project, error) {
+	project, err := s.buildProject(ctx, userID, dto)
+	if err != nil {
+		return nil, err
+	}
+	project.ID = id
+
+	err = s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.repo.Update(ctx, project); err != nil {
+			return err
+		}
+		project, err = s.repo.GetByID(ctx, userID, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return project, nil
+}
+
+func (s *ProjectService) DeleteProject(ctx context.Context, userID uint, id uint) error {
+	return s.repo.Delete(ctx, userID, id)
+}
+
+// GetShortfall compare les besoins du projet au stock de l'utilisateur et
+// renvoie, par coloris, le nombre d'échevettes manquantes.
+func (s *ProjectService) GetShortfall(ctx context.Context, userID uint, id uint) (*ProjectShortfall, error) {
+	project, err := s.repo.GetByID(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Un même coloris peut apparaître plusieurs fois (ex. en mètres et en échevettes)
+	var keys []ThreadKey
+	required := map[ThreadKey]float64{}
+	for _, req := range project.Requirements {
+		key := ThreadKey{Brand: req.Brand, ThreadId: req.ThreadId}
+		if _, ok := required[key]; !ok {
+			keys = append(keys, key)
+		}
+		skeins := req.Quantity
+		if req.Unit == UnitMetre {
+			skeins = req.Quantity / s.catalog.SkeinLength(ctx, req.Brand)
+		}
+		required[key] += skeins
+	}
+
+	threads, err := s.threadRepo.GetByKeys(ctx, userID, keys)
+	if err != nil {
+		return nil, err
+	}
+	owned := make(map[ThreadKey]int64, len(threads))
+	for _, t := range threads {
+		owned[ThreadKey{Brand: t.Brand, ThreadId: t.ThreadId}] = t.ThreadCount
+	}
+
+	shortfall := &ProjectShortfall{ProjectID: project.ID, Items: make([]ShortfallItem, 0, len(keys))}
+	for _, key := range keys {
+		item := ShortfallItem{
+			Brand:          key.Brand,
+			ThreadId:       key.ThreadId,
+			RequiredSkeins: math.Round(required[key]*100) / 100,
+			Owned:          owned[key],
+		}
+		// Une échevette entamée ne s'achète pas : on arrondit au supérieur
+		if missing := int64(math.Ceil(required[key]-1e-9)) - item.Owned; missing > 0 {
+			item.Missing = missing
+		}
+		shortfall.TotalMissing += item.Missing
+		shortfall.Items = append(shortfall.Items, item)
+	}
+	return shortfall, nil
+}
+
+// --- Tag Service ---
+
+var ErrInvalidTag = errors.New("invalid tag")
+
+type TagService struct {
+	repo       TagRepository
+	threadRepo ThreadRepository
+	tx         Transactor
+	log        *slog.Logger
+}
+
+func NewTagService(repo TagRepository, threadRepo ThreadRepository, tx Transactor, log *slog.Logger) *TagService {
+	return &TagService{repo: repo, threadRepo: threadRepo, tx: tx, log: log}
+}
+
+func (s *TagService) GetTags(ctx context.Context, userID uint) ([]Tag, error) {
+	tags, err := s.repo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if tags == nil {
+		tags = []Tag{}
+	}
+	return tags, nil
+}
+
+func (s *TagService) CreateTag(ctx context.Context, userID uint, dto TagDto) (*Tag, error) {
+	tag := &Tag{UserID: userID}
+	err := s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.applyTagDto(ctx, tag, dto); err != nil {
+			return err
+		}
+		return s.repo.Create(ctx, tag)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tag, nil
+}
+
+func (s *TagService) UpdateTag(ctx context.Context, userID uint, id uint, dto TagDto) (*Tag, error) {
+	tag := &Tag{ID: id, UserID: userID}
+	err := s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.applyTagDto(ctx, tag, dto); err != nil {
+			return err
+		}
+		return s.repo.Update(ctx, tag)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tag, nil
+}
+
+// applyTagDto valide le nom, unique par utilisateur sans tenir compte de la casse.
+func (s *TagService) applyTagDto(ctx context.Context, tag *Tag, dto TagDto) error {
+	name := strings.TrimSpace(dto.Name)
+	if name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidTag)
+	}
+	if dto.Color != "" {
+		if _, _, _, err := ParseHexColor(dto.Color); err != nil {
+			return fmt.Errorf("%w: invalid color", ErrInvalidTag)
+		}
+	}
+
+	tags, err := s.repo.GetByUserID(ctx, tag.UserID)
+	if err != nil {
+		return err
+	}
+	for _, t := range tags {
+		if t.ID != tag.ID && strings.EqualFold(t.Name, name) {
+			return fmt.Errorf("%w: %q already exists", ErrInvalidTag, t.Name)
+		}
+	}
+
+	tag.Name = name
+	tag.Color = dto.Color
+	return nil
+}
+
+func (s *TagService) DeleteTag(ctx context.Context, userID uint, id uint) error {
+	return s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		return s.repo.Delete(ctx, userID, id)
+	})
+}
+
+// TagThreads pose les étiquettes sur les fils sélectionnés. Tout ou rien : un
+// fil ou une étiquette inconnue annule l'opération.
+func (s *TagService) TagThreads(ctx context.Context, userID uint, dto ThreadTagsDto) error {
+	return s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		threadIDs, tagIDs, err := s.checkSelection(ctx, userID, dto)
+		if err != nil {
+			return err
+		}
+		return s.repo.Attach(ctx, threadIDs, tagIDs)
+	})
+}
+
+// UntagThreads retire les étiquettes des fils sélectionnés.
+func (s *TagService) UntagThreads(ctx context.Context, userID uint, dto ThreadTagsDto) error {
+	return s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		threadIDs, tagIDs, err := s.checkSelection(ctx, userID, dto)
+		if err != nil {
+			return err
+		}
+		return s.repo.Detach(ctx, threadIDs, tagIDs)
+	})
+}
+
+func (s *TagService) checkSelection(ctx context.Context, userID uint, dto ThreadTagsDto) ([]uint, []uint, error) {
+	threadIDs, tagIDs := uniqueIDs(dto.ThreadIDs), uniqueIDs(dto.TagIDs)
+	if len(threadIDs) == 0 || len(tagIDs) == 0 {
+		return nil, nil, fmt.Errorf("%w: thread_ids and tag_ids are required", ErrInvalidTag)
+	}
+
+	threads, err := s.threadRepo.GetByIDs(ctx, userID, threadIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	tags, err := s.repo.GetByIDs(ctx, userID, tagIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(threads) != len(threadIDs) || len(tags) != len(tagIDs) {
+		return nil, nil, gorm.ErrRecordNotFound
+	}
+	return threadIDs, tagIDs, nil
+}
+
+// --- Location Service ---
+
+var ErrInvalidLocation = errors.New("invalid location")
+
+// locationKinds donne la profondeur de chaque type d'emplacement : un
+// emplacement ne peut contenir que des types plus profonds que le sien.
+var locationKinds = map[string]int{
+	"box":    0,
+	"drawer": 1,
+	"slot":   2,
+}
+
+type LocationService struct {
+	repo       LocationRepository
+	threadRepo ThreadRepository
+	catalog    *CatalogService
+	tx         Transactor
+	log        *slog.Logger
+}
+
+func NewLocationService(repo LocationRepository, threadRepo ThreadRepository, catalog *CatalogService, tx Transactor, log *slog.Logger) *LocationService {
+	return &LocationService{repo: repo, threadRepo: threadRepo, catalog: catalog, tx: tx, log: log}
+}
+
+// GetTree renvoie les emplacements de l'utilisateur sous forme d'arbre.
+func (s *LocationService) GetTree(ctx context.Context, userID uint) ([]*LocationTree, error) {
+	locations, err := s.repo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[uint]*LocationTree, len(locations))
+	for _, l := range locations {
+		nodes[l.ID] = &LocationTree{Location: l, Children: []*LocationTree{}}
+	}
+	roots := []*LocationTree{}
+	for _, l := range locations {
+		node := nodes[l.ID]
+		if l.ParentID != nil {
+			if parent, ok := nodes[*l.ParentID]; ok {
+				parent.Children = append(parent.Children, node)
+				continue
+			}
+		}
+		roots = append(roots, node)
+	}
+	return roots, nil
+}
+
+func (s *LocationService) CreateLocation(ctx context.Context, userID uint, dto LocationDto) (*Location, error) {
+	location := &Location{UserID: userID}
+	err := s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		locations, err := s.repo.GetByUserID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if err := applyLocationDto(location, dto, locations); err != nil {
+			return err
+		}
+		return s.repo.Create(ctx, location)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return location, nil
+}
+
+func (s *LocationService) UpdateLocation(ctx context.Context, userID uint, id uint, dto LocationDto) (*Location, error) {
+	var location *Location
+	err := s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		if location, err = s.repo.GetByID(ctx, userID, id); err != nil {
+			return err
+		}
+		locations, err := s.repo.GetByUserID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if err := applyLocationDto(location, dto, locations); err != nil {
+			return err
+		}
+		return s.repo.Update(ctx, location)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return location, nil
+}
+
+// applyLocationDto valide le nom, le type et le parent de l'emplacement par
+// rapport aux emplacements existants de l'utilisateur.
+func applyLocationDto(location *Location, dto LocationDto, locations []Location) error {
+	name := strings.TrimSpace(dto.Name)
+	if name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidLocation)
+	}
+	rank, ok := locationKinds[dto.Kind]
+	if !ok {
+		return fmt.Errorf("%w: kind must be box, drawer or slot", ErrInvalidLocation)
+	}
+
+	byID := make(map[uint]Location, len(locations))
+	for _, l := range locations {
+		byID[l.ID] = l
+	}
+	if dto.ParentID != nil {
+		parent, ok := byID[*dto.ParentID]
+		if !ok {
+			return fmt.Errorf("%w: unknown parent", ErrInvalidLocation)
+		}
+		if locationKinds[parent.Kind] >= rank {
+			return fmt.Errorf("%w: a %s cannot contain a %s", ErrInvalidLocation, parent.Kind, dto.Kind)
+		}
+	}
+
+	// Emplacement existant : pas de cycle, et ses enfants doivent rester plus profonds
+	if location.ID != 0 {
+		subtree := locationSubtree(locations, location.ID)
+		for _, id := range subtree {
+			if dto.ParentID != nil && *dto.ParentID == id {
+				return fmt.Errorf("%w: a location cannot be moved into itself", ErrInvalidLocation)
+			}
+		}
+		for _, l := range locations {
+			if l.ParentID != nil && *l.ParentID == location.ID && locationKinds[l.Kind] <= rank {
+				return fmt.Errorf("%w: a %s cannot contain a %s", ErrInvalidLocation, dto.Kind, l.Kind)
+			}
+		}
+	}
+
+	location.Name = name
+	location.Kind = dto.Kind
+	location.ParentID = dto.ParentID
+	return nil
+}
+
+// DeleteLocation supprime un emplacement vide de sous-emplacements. Les fils
+// qu'il contenait n'ont plus d'emplacement.
+func (s *LocationService) DeleteLocation(ctx context.Context, userID uint, id uint) error {
+	return s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		locations, err := s.repo.GetByUserID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if len(locationSubtree(locations, id)) > 1 {
+			return fmt.Errorf("%w: location still contains other locations", ErrInvalidLocation)
+		}
+		if _, err := s.threadRepo.MoveLocationContents(ctx, userID, id, nil); err != nil {
+			return err
+		}
+		return s.repo.Delete(ctx, userID, id)
+	})
+}
+
+// MoveThreads déplace des fils, ou tout le contenu d'un emplacement, vers un
+// autre emplacement.
+func (s *LocationService) MoveThreads(ctx context.Context, userID uint, dto MoveThreadsDto) (*MoveThreadsResult, error) {
+	if (len(dto.ThreadIDs) == 0) == (dto.FromLocationID == nil) {
+		return nil, fmt.Errorf("%w: either thread_ids or from_location_id is required", ErrInvalidLocation)
+	}
+
+	result := &MoveThreadsResult{}
+	err := s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		for _, id := range []*uint{dto.LocationID, dto.FromLocationID} {
+			if id == nil {
+				continue
+			}
+			if _, err := s.repo.GetByID(ctx, userID, *id); errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidLocation
+			} else if err != nil {
+				return err
+			}
+		}
+
+		var err error
+		if dto.FromLocationID != nil {
+			result.Moved, err = s.threadRepo.MoveLocationContents(ctx, userID, *dto.FromLocationID, dto.LocationID)
+			return err
+		}
+		result.Moved, err = s.threadRepo.SetLocation(ctx, userID, dto.ThreadIDs, dto.LocationID)
+		if err != nil {
+			return err
+		}
+		// Tout ou rien : un identifiant inconnu annule le déplacement
+		if result.Moved != int64(len(uniqueIDs(dto.ThreadIDs))) {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// WhereIs renvoie l'emplacement d'un fil avec le chemin complet depuis la boîte.
+func (s *LocationService) WhereIs(ctx context.Context, userID uint, brand, number string) (*ThreadLocation, error) {
+	key := Thread{Brand: brand, ThreadId: number, IsCustom: true}
+	if err := s.catalog.ResolveThread(ctx, &key); err != nil {
+		return nil, err
+	}
+	threads, err := s.threadRepo.GetByKeys(ctx, userID, []ThreadKey{{Brand: key.Brand, ThreadId: key.ThreadId}})
+	if err != nil {
+		return nil, err
+	}
+	if len(threads) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	result := &ThreadLocation{Thread: threads[0], Path: []Location{}}
+	if result.Thread.LocationID == nil {
+		return result, nil
+	}
+	locations, err := s.repo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]Location, len(locations))
+	for _, l := range locations {
+		byID[l.ID] = l
+	}
+
+	names := []string{}
+	for id := result.Thread.LocationID; id != nil; {
+		l, ok := byID[*id]
+		// Garde-fou contre un cycle qui aurait échappé à la validation
+		if !ok || len(result.Path) > len(locations) {
+			break
+		}
+		result.Path = append([]Location{l}, result.Path...)
+		names = append([]string{l.Name}, names...)
+		id = l.ParentID
+	}
+	result.Label = strings.Join(names, " > ")
+	return result, nil
+}
+
+// locationSubtree renvoie l'emplacement root et tous ses descendants, ou une
+// liste vide si root n'existe pas.
+func locationSubtree(locations []Location, root uint) []uint {
+	children := map[uint][]uint{}
+	found := false
+	for _, l := range locations {
+		if l.ID == root {
+			found = true
+		}
+		if l.ParentID != nil {
+			children[*l.ParentID] = append(children[*l.ParentID], l.ID)
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	ids := []uint{root}
+	seen := map[uint]bool{root: true}
+	for i := 0; i < len(ids); i++ {
+		for _, child := range children[ids[i]] {
+			if !seen[child] {
+				seen[child] = true
+				ids = append(ids, child)
+			}
+		}
+	}
+	return ids
+}
+
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	unique := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// --- Shopping List Service ---
+
+const (
+	ShoppingSourceManual = "manual"
+	ShoppingSourceAuto   = "auto"
+)
+
+type ShoppingListService struct {
+	repo    ShoppingListRepository
+	threads *ThreadService
+	catalog *CatalogService
+	tx      Transactor
+	log     *slog.Logger
+}
+
+func NewShoppingListService(repo ShoppingListRepository, threads *ThreadService, catalog *CatalogService, tx Transactor, log *slog.Logger) *ShoppingListService {
+	return &ShoppingListService{repo: repo, threads: threads, catalog: catalog, tx: tx, log: log}
+}
+
+func (s *ShoppingListService) GetItems(ctx context.Context, userID uint, includeBought bool) ([]ShoppingListItem, error) {
+	items, err := s.repo.GetByUserID(ctx, userID, includeBought)
+	if err != nil {
+		return nil, err
+	}
+	if items == nil {
+		items = []ShoppingListItem{}
+	}
+	return items, nil
+}
+
+// AddItem ajoute un coloris à la liste, ou augmente la quantité de l'article
+// déjà présent pour ce coloris.
+func (s *ShoppingListService) AddItem(ctx context.Context, userID uint, dto ShoppingListItemDto) (*ShoppingListItem, error) {
+	if dto.Quantity < 0 {
+		return nil, errors.New("quantity must be positive")
+	}
+	if dto.Quantity == 0 {
+		dto.Quantity = 1
+	}
+	key := Thread{Brand: dto.Brand, ThreadId: dto.ThreadId, IsCustom: true}
+	if err := s.catalog.ResolveThread(ctx, &key); err != nil {
+		return nil, err
+	}
+	if key.ThreadId == "" {
+		return nil, errors.New("thread_id is required")
+	}
+
+	var item *ShoppingListItem
+	err := s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		item, err = s.repo.GetOpenByKey(ctx, userID, ThreadKey{Brand: key.Brand, ThreadId: key.ThreadId})
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			item = &ShoppingListItem{
+				UserID:   userID,
+				Brand:    key.Brand,
+				ThreadId: key.ThreadId,
+				Quantity: dto.Quantity,
+				Source:   ShoppingSourceManual,
+				Note:     dto.Note,
+			}
+			return s.repo.Create(ctx, item)
+		}
+		if err != nil {
+			return err
+		}
+
+		// Un article ajouté à la main n'est plus retiré automatiquement
+		item.Quantity += dto.Quantity
+		item.Source = ShoppingSourceManual
+		if dto.Note != "" {
+			item.Note = dto.Note
+		}
+		return s.repo.Update(ctx, item)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (s *ShoppingListService) DeleteItem(ctx context.Context, userID uint, id uint) error {
+	return s.repo.Delete(ctx, userID, id)
+}
+
+// Receive marque les articles comme achetés et ajoute les quantités reçues au
+// stock, le tout dans une seule transaction.
+func (s *ShoppingListService) Receive(ctx context.Context, userID uint, dto ShoppingListReceiveDto) (*ShoppingListReceipt, error) {
+	if len(dto.Items) == 0 {
+		return nil, errors.New("no items to receive")
+	}
+	ids := make([]uint, 0, len(dto.Items))
+	quantities := make(map[uint]int64, len(dto.Items))
+	for _, it := range dto.Items {
+		if it.Quantity < 0 {
+			return nil, fmt.Errorf("item %d: quantity must be positive", it.ID)
+		}
+		if _, ok := quantities[it.ID]; ok {
+			return nil, fmt.Errorf("item %d is listed twice", it.ID)
+		}
+		ids = append(ids, it.ID)
+		quantities[it.ID] = it.Quantity
+	}
+
+	receipt := &ShoppingListReceipt{}
+	err := s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		items, err := s.repo.GetOpenByIDs(ctx, userID, ids)
+		if err != nil {
+			return err
+		}
+		if len(items) != len(ids) {
+			return gorm.ErrRecordNotFound
+		}
+
+		// Les articles sont clos avant la mise à jour du stock, qui retire
+		// sinon les articles automatiques d'un fil de nouveau disponible
+		now := time.Now()
+		if err := s.repo.MarkBought(ctx, userID, ids, now); err != nil {
+			return err
+		}
+		for _, item := range items {
+			if q := quantities[item.ID]; q > 0 && q != item.Quantity {
+				item.Quantity = q
+				if err := s.repo.Update(ctx, &item); err != nil {
+					return err
+				}
+			}
+			item.BoughtAt = &now
+			thread, err := s.threads.AddStock(ctx, userID, ThreadKey{Brand: item.Brand, ThreadId: item.ThreadId}, item.Quantity, "purchase")
+			if err != nil {
+				return err
+			}
+			receipt.Items = append(receipt.Items, item)
+			receipt.Threads = append(receipt.Threads, *thread)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return receipt, nil
+}
+
+// --- Pattern Service ---
+
+const (
+	defaultFabricCount = 14
+	defaultStrands     = 2
+	// Les points arrière se brodent le plus souvent à un brin
+	defaultBackstitchStrands = 1
+	// Marque retenue pour les coloris de la légende sans préfixe de marque
+	defaultPatternBrand = "DMC"
+)
+
+type PatternService struct {
+	threadRepo ThreadRepository
+	chartRepo  ChartRepository
+	catalog    *CatalogService
+	log        *slog.Logger
+}
+
+func NewPatternService(threadRepo ThreadRepository, chartRepo ChartRepository, catalog *CatalogService, log *slog.Logger) *PatternService {
+	return &PatternService{threadRepo: threadRepo, chartRepo: chartRepo, catalog: catalog, log: log}
+}
+
+// ImportOXS lit la légende d'une grille OXS, estime le nombre d'échevettes de
+// chaque coloris et le compare au stock de l'utilisateur.
+func (s *PatternService) ImportOXS(ctx context.Context, userID uint, r io.Reader, opts PatternImportOptions) (*PatternImport, error) {
+	if opts.FabricCount <= 0 {
+		opts.FabricCount = defaultFabricCount
+	}
+	if opts.Strands <= 0 {
+		opts.Strands = defaultStrands
+	}
+	if strings.TrimSpace(opts.Brand) == "" {
+		opts.Brand = defaultPatternBrand
+	}
+
+	chart, err := ParseOXS(r)
+	if err != nil {
+		return nil, err
+	}
+
+	fabric := skein.Fabric{Count: float64(opts.FabricCount)}
+
+	var keys []ThreadKey
+	items := map[ThreadKey]*PatternThread{}
+	// Position dans la légende de chaque index de palette OXS
+	positions := map[int]int{}
+	for _, entry := range chart.Palette {
+		brand := entry.Brand
+		if brand == "" {
+			brand = opts.Brand
+		}
+		key := Thread{Brand: brand, ThreadId: entry.Number, IsCustom: true}
+		if err := s.catalog.ResolveThread(ctx, &key); err != nil {
+			return nil, err
+		}
+
+		strands := entry.Strands
+		if strands <= 0 {
+			strands = opts.Strands
+		}
+		bsStrands := entry.BackstitchStrands
+		if bsStrands <= 0 {
+			bsStrands = defaultBackstitchStrands
+		}
+		stitches, err := skein.Length(skein.Usage{
+			Full:  float64(entry.FullStitches),
+			Half:  float64(entry.PartStitches),
+			Knots: float64(entry.Knots),
+		}, skein.Params{Fabric: fabric, Strands: strands, WasteFactor: skein.DefaultWasteFactor})
+		if err != nil {
+			return nil, err
+		}
+		backstitches, err := skein.Length(skein.Usage{Backstitch: entry.BackstitchLength},
+			skein.Params{Fabric: fabric, Strands: bsStrands, WasteFactor: skein.DefaultWasteFactor})
+		if err != nil {
+			return nil, err
+		}
+		// Longueur de brin simple, ramenée à la longueur de fil complet (tous brins)
+		metres := (stitches + backstitches) / float64(s.catalog.Skein(ctx, key.Brand).Strands)
+
+		k := ThreadKey{Brand: key.Brand, ThreadId: key.ThreadId}
+		item, ok := items[k]
+		if !ok {
+			item = &PatternThread{
+				Brand:     key.Brand,
+				ThreadId:  key.ThreadId,
+				Name:      entry.Name,
+				Hex:       entry.Color,
+				InCatalog: !key.IsCustom,
+			}
+			items[k] = item
+			keys = append(keys, k)
+		}
+		positions[entry.Index] = slices.Index(keys, k)
+		item.Stitches += entry.Stitches()
+		item.BackstitchLength += entry.BackstitchLength
+		item.Knots += entry.Knots
+		item.EstimatedMetres += metres
+	}
+
+	result := &PatternImport{
+		Title:       chart.Title,
+		Width:       chart.Width,
+		Height:      chart.Height,
+		FabricCount: opts.FabricCount,
+		Warnings:    chart.Warnings,
+	}
+	if err := s.compareStock(ctx, userID, keys, items, result); err != nil {
+		return nil, err
+	}
+
+	saved, err := s.saveOXSChart(ctx, userID, chart, positions, result)
+	if err != nil {
+		return nil, err
+	}
+	if saved != nil {
+		result.ChartID = saved.ID
+	}
+	return result, nil
+}
+
+// maxChartSize borne les grilles OXS enregistrées, en points par côté.
+const maxChartSize = 1000
+
+// saveOXSChart enregistre la grille importée pour pouvoir l'imprimer. Une
+// grille vide ou démesurée n'est pas enregistrée et le signale dans Warnings.
+func (s *PatternService) saveOXSChart(ctx context.Context, userID uint, chart *OXSChart, positions map[int]int, result *PatternImport) (*Chart, error) {
+	width, height := chart.Width, chart.Height
+	if width <= 0 || height <= 0 {
+		for _, st := range chart.Stitches {
+			width = max(width, st.X+1)
+			height = max(height, st.Y+1)
+		}
+	}
+	if width <= 0 || height <= 0 || width > maxChartSize || height > maxChartSize {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("chart grid of %dx%d stitches was not saved", width, height))
+		return nil, nil
+	}
+
+	saved := &Chart{
+		UserID: userID,
+		Title:  chart.Title,
+		Source: ChartSourceOXS,
+		Width:  width,
+		Height: height,
+		Cells:  make([]int, width*height),
+		Colors: make([]ChartColor, len(result.Threads)),
+	}
+	for i := range saved.Cells {
+		saved.Cells[i] = -1
+	}
+	for _, st := range chart.Stitches {
+		position, ok := positions[st.Index]
+		if !ok || st.X >= width || st.Y >= height {
+			continue
+		}
+		saved.Cells[st.Y*width+st.X] = position
+	}
+	for i, t := range result.Threads {
+		saved.Colors[i] = ChartColor{Brand: t.Brand, ThreadId: t.ThreadId, Name: t.Name, Hex: t.Hex}
+	}
+	if err := s.chartRepo.Create(ctx, saved); err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+// compareStock complète la légende avec le stock de l'utilisateur et le nombre
+// d'échevettes manquantes, dans l'ordre de keys.
+func (s *PatternService) compareStock(ctx context.Context, userID uint, keys []ThreadKey, items map[ThreadKey]*PatternThread, result *PatternImport) error {
+	threads, err := s.threadRepo.GetByKeys(ctx, userID, keys)
+	if err != nil {
+		return err
+	}
+	for _, t := range threads {
+		if item, ok := items[ThreadKey{Brand: t.Brand, ThreadId: t.ThreadId}]; ok {
+			item.Owned = t.ThreadCount
+		}
+	}
+
+	result.Threads = make([]PatternThread, 0, len(keys))
+	for _, k := range keys {
+		item := items[k]
+		skeins := item.EstimatedMetres / s.catalog.SkeinLength(ctx, item.Brand)
+		item.EstimatedSkeins = math.Round(skeins*100) / 100
+		item.EstimatedMetres = math.Round(item.EstimatedMetres*100) / 100
+		item.BackstitchLength = math.Round(item.BackstitchLength*100) / 100
+		if missing := skein.ToBuy(skeins) - item.Owned; missing > 0 {
+			item.Missing = missing
+		}
+		result.TotalMissing += item.Missing
+		result.Threads = append(result.Threads, *item)
+	}
+	return nil
+}
+
+// defaultPatternColors est le nombre de coloris d'une grille générée sans limite explicite.
+const defaultPatternColors = 20
+
+// GenerateFromImage convertit une image PNG ou JPEG en grille de points aux
+// coloris de la marque demandée, puis compare la légende au stock.
+func (s *PatternService) GenerateFromImage(ctx context.Context, userID uint, r io.Reader, opts ImagePatternOptions) (*PatternGeneration, error) {
+	if opts.FabricCount <= 0 {
+		opts.FabricCount = defaultFabricCount
+	}
+	if opts.Strands <= 0 {
+		opts.Strands = defaultStrands
+	}
+	if opts.MaxColors <= 0 {
+		opts.MaxColors = defaultPatternColors
+	}
+	if opts.MaxColors > maxPatternColors {
+		return nil, fmt.Errorf("%w: at most %d colours", ErrInvalidImage, maxPatternColors)
+	}
+	if strings.TrimSpace(opts.Brand) == "" {
+		opts.Brand = defaultPatternBrand
+	}
+
+	colors, err := s.catalog.GetColors(ctx, opts.Brand, "", false)
+	if err != nil {
+		return nil, err
+	}
+	if len(colors) == 0 {
+		return nil, fmt.Errorf("%w: no catalogue colours for brand %s", ErrInvalidImage, opts.Brand)
+	}
+	brand, err := s.catalog.GetBrand(ctx, opts.Brand)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := decodePatternImage(r)
+	if err != nil {
+		return nil, err
+	}
+	width, height, err := patternSize(img.Bounds(), opts.Width, opts.Height)
+	if err != nil {
+		return nil, err
+	}
+
+	palette := make([]Lab, len(colors))
+	for i, c := range colors {
+		palette[i] = RGBToLab(c.R, c.G, c.B)
+	}
+	quantized := quantizeImage(img, palette, quantizeOptions{
+		Width:     width,
+		Height:    height,
+		MaxColors: opts.MaxColors,
+		Dither:    opts.Dither,
+	})
+
+	params := skein.Params{
+		Fabric:      skein.Fabric{Count: float64(opts.FabricCount)},
+		Strands:     opts.Strands,
+		WasteFactor: skein.DefaultWasteFactor,
+	}
+	sk := s.catalog.Skein(ctx, brand.Name)
+
+	keys := make([]ThreadKey, 0, len(quantized.Colors))
+	items := make(map[ThreadKey]*PatternThread, len(quantized.Colors))
+	for i, idx := range quantized.Colors {
+		c := colors[idx]
+		length, err := skein.Length(skein.Usage{Full: float64(quantized.Counts[i])}, params)
+		if err != nil {
+			return nil, err
+		}
+		k := ThreadKey{Brand: brand.Name, ThreadId: c.Number}
+		keys = append(keys, k)
+		items[k] = &PatternThread{
+			Brand:           brand.Name,
+			ThreadId:        c.Number,
+			Name:            c.Name,
+			Hex:             c.Hex,
+			InCatalog:       true,
+			Stitches:        float64(quantized.Counts[i]),
+			EstimatedMetres: length / float64(sk.Strands),
+		}
+	}
+
+	result := &PatternGeneration{
+		PatternImport: PatternImport{
+			Title:       opts.Title,
+			Width:       width,
+			Height:      height,
+			FabricCount: opts.FabricCount,
+		},
+		Grid: quantized.Grid.Rows(),
+	}
+	if err := s.compareStock(ctx, userID, keys, items, &result.PatternImport); err != nil {
+		return nil, err
+	}
+
+	saved := &Chart{
+		UserID: userID,
+		Title:  opts.Title,
+		Source: ChartSourceImage,
+		Width:  width,
+		Height: height,
+		Cells:  quantized.Grid.Cells,
+		Colors: make([]ChartColor, len(result.Threads)),
+	}
+	for i, t := range result.Threads {
+		saved.Colors[i] = ChartColor{Brand: t.Brand, ThreadId: t.ThreadId, Name: t.Name, Hex: t.Hex}
+	}
+	if err := s.chartRepo.Create(ctx, saved); err != nil {
+		return nil, err
+	}
+	result.ChartID = saved.ID
+	return result, nil
+}
+
+// --- Chart Service ---
+
+const (
+	ChartSourceOXS   = "oxs"
+	ChartSourceImage = "image"
+)
+
+type ChartService struct {
+	repo       ChartRepository
+	threadRepo ThreadRepository
+	log        *slog.Logger
+}
+
+func NewChartService(repo ChartRepository, threadRepo ThreadRepository, log *slog.Logger) *ChartService {
+	return &ChartService{repo: repo, threadRepo: threadRepo, log: log}
+}
+
+func (s *ChartService) GetCharts(ctx context.Context, userID uint) ([]Chart, error) {
+	return s.repo.GetByUserID(ctx, userID)
+}
+
+func (s *ChartService) GetChart(ctx context.Context, userID uint, id uint) (*ChartDetail, error) {
+	chart, err := s.repo.GetByID(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	return &ChartDetail{Chart: *chart, Grid: chart.Grid().Rows()}, nil
+}
+
+func (s *ChartService) DeleteChart(ctx context.Context, userID uint, id uint) error {
+	return s.repo.Delete(ctx, userID, id)
+}
+
+// Render imprime la grille : les pages de grille, puis la légende avec le
+// stock possédé de chaque coloris.
+func (s *ChartService) Render(ctx context.Context, userID uint, id uint, opts ChartRenderOptions) (*RenderedChart, error) {
+	if opts.Format == "" {
+		opts.Format = ChartFormatPDF
+	}
+	if opts.Mode == "" {
+		opts.Mode = ChartModeSymbol
+	}
+	if opts.Mode != ChartModeSymbol && opts.Mode != ChartModeColor {
+		return nil, fmt.Errorf("%w: mode must be symbol or color", ErrInvalidRender)
+	}
+
+	chart, err := s.repo.GetByID(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	key, err := s.chartKey(ctx, userID, chart)
+	if err != nil {
+		return nil, err
+	}
+	layout := newChartLayout(chart, key, opts.Mode)
+
+	rendered := &RenderedChart{Pages: layout.Pages(), Filename: fmt.Sprintf("chart-%d", chart.ID)}
+	if opts.Format != ChartFormatPDF {
+		if opts.Page == 0 {
+			opts.Page = 1
+		}
+		if opts.Page < 1 || opts.Page > rendered.Pages {
+			return nil, fmt.Errorf("%w: page must be between 1 and %d", ErrInvalidRender, rendered.Pages)
+		}
+		rendered.Filename += fmt.Sprintf("-%d", opts.Page)
+	}
+
+	var buf bytes.Buffer
+	switch opts.Format {
+	case ChartFormatSVG:
+		rendered.ContentType = "image/svg+xml"
+		err = writeChartSVG(&buf, layout, opts.Page-1)
+	case ChartFormatPNG:
+		rendered.ContentType = "image/png"
+		err = writeChartPNG(&buf, layout, opts.Page-1)
+	case ChartFormatPDF:
+		rendered.ContentType = "application/pdf"
+		err = writeChartPDF(&buf, layout)
+	default:
+		return nil, fmt.Errorf("%w: format must be svg, png or pdf", ErrInvalidRender)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rendered.Filename += "." + opts.Format
+	rendered.Data = buf.Bytes()
+	return rendered, nil
+}
+
+func (s *ChartService) chartKey(ctx context.Context, userID uint, chart *Chart) ([]ChartKeyEntry, error) {
+	key := make([]ChartKeyEntry, len(chart.Colors))
+	keys := make([]ThreadKey, len(chart.Colors))
+	positions := make(map[ThreadKey]int, len(chart.Colors))
+	for i, c := range chart.Colors {
+		key[i] = ChartKeyEntry{Symbol: chartSymbol(i), Color: c}
+		keys[i] = ThreadKey{Brand: c.Brand, ThreadId: c.ThreadId}
+		positions[keys[i]] = i
+	}
+	for _, idx := range chart.Cells {
+		if idx >= 0 && idx < len(key) {
+			key[idx].Stitches++
+		}
+	}
+
+	threads, err := s.threadRepo.GetByKeys(ctx, userID, keys)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range threads {
+		if i, ok := positions[ThreadKey{Brand: t.Brand, ThreadId: t.ThreadId}]; ok {
+			key[i].Owned = t.ThreadCount
+		}
+	}
+	return key, nil
+}
+
+// --- Calculator Service ---
+
+var ErrInvalidCalculation = errors.New("invalid calculation")
+
+const (
+	StitchFull       = "full"
+	StitchHalf       = "half"
+	StitchBackstitch = "backstitch"
+)
+
+type CalculatorService struct {
+	catalog *CatalogService
+	log     *slog.Logger
+}
+
+func NewCalculatorService(catalog *CatalogService, log *slog.Logger) *CalculatorService {
+	return &CalculatorService{catalog: catalog, log: log}
+}
+
+// CalculateSkeins estime le nombre d'échevettes nécessaires pour chaque
+// marque demandée, ou pour toutes les marques du catalogue.
+func (s *CalculatorService) CalculateSkeins(ctx context.Context, dto SkeinCalculationDto) (*SkeinCalculation, error) {
+	if dto.FabricCount == 0 {
+		dto.FabricCount = defaultFabricCount
+	}
+	if dto.Strands == 0 {
+		dto.Strands = defaultStrands
+	}
+	if dto.StitchType == "" {
+		dto.StitchType = StitchFull
+	}
+	waste := skein.DefaultWasteFactor
+	if dto.WasteFactor != nil {
+		waste = *dto.WasteFactor
+	}
+
+	var usage skein.Usage
+	var stitches float64
+	switch dto.StitchType {
+	case StitchFull, StitchHalf:
+		switch {
+		case dto.Stitches != nil:
+			stitches = *dto.Stitches
+		case dto.Width > 0 && dto.Height > 0:
+			if dto.Coverage <= 0 || dto.Coverage > 1 {
+				return nil, fmt.Errorf("%w: coverage must be between 0 and 1", ErrInvalidCalculation)
+			}
+			stitches = skein.CoveredStitches(dto.Width, dto.Height, dto.Coverage)
+		default:
+			return nil, fmt.Errorf("%w: stitches or width, height and coverage are required", ErrInvalidCalculation)
+		}
+		if stitches < 0 {
+			return nil, fmt.Errorf("%w: stitches cannot be negative", ErrInvalidCalculation)
+		}
+		if dto.StitchType == StitchFull {
+			usage.Full = stitches
+		} else {
+			usage.Half = stitches
+		}
+	case StitchBackstitch:
+		if dto.BackstitchLength <= 0 {
+			return nil, fmt.Errorf("%w: backstitch_length is required", ErrInvalidCalculation)
+		}
+		usage.Backstitch = dto.BackstitchLength
+	default:
+		return nil, fmt.Errorf("%w: stitch_type must be full, half or backstitch", ErrInvalidCalculation)
+	}
+
+	params := skein.Params{
+		Fabric:      skein.Fabric{Count: dto.FabricCount, OverTwo: dto.OverTwo},
+		Strands:     dto.Strands,
+		WasteFactor: waste,
+	}
+	length, err := skein.Length(usage, params)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCalculation, err)
+	}
+
+	brands := dto.Brands
+	if len(brands) == 0 {
+		all, err := s.catalog.GetBrands(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range all {
+			brands = append(brands, b.Name)
+		}
+	}
+
+	result := &SkeinCalculation{
+		StitchType:      dto.StitchType,
+		Stitches:        stitches,
+		StitchesPerInch: params.Fabric.StitchesPerInch(),
+		Strands:         dto.Strands,
+		WasteFactor:     waste,
+		Metres:          math.Round(length*100) / 100,
+		Brands:          make([]BrandSkeinEstimate, 0, len(brands)),
+	}
+	for _, name := range brands {
+		// Une marque hors catalogue est estimée avec une échevette standard
+		brand := strings.TrimSpace(name)
+		if b, err := s.catalog.GetBrand(ctx, brand); err == nil {
+			brand = b.Name
+		}
+		sk := s.catalog.Skein(ctx, brand)
+		skeins, err := skein.Skeins(length, sk)
+		if err != nil {
+			return nil, err
+		}
+		result.Brands = append(result.Brands, BrandSkeinEstimate{
+			Brand:        brand,
+			SkeinLength:  sk.Length,
+			SkeinStrands: sk.Strands,
+			Skeins:       math.Round(skeins*100) / 100,
+			ToBuy:        skein.ToBuy(skeins),
+		})
+	}
+	return result, nil
+}
+
+// --- Conversion Service ---
+
+const defaultConversionCandidates = 3
+
+type ConversionService struct {
+	catalogRepo CatalogRepository
+	threadRepo  ThreadRepository
+	log         *slog.Logger
+}
+
+func NewConversionService(catalogRepo CatalogRepository, threadRepo ThreadRepository, log *slog.Logger) *ConversionService {
+	return &ConversionService{catalogRepo: catalogRepo, threadRepo: threadRepo, log: log}
+}
+
+// Convert renvoie les équivalents officiels du coloris dans la marque cible,
+// ou à défaut les limit coloris les plus proches (ΔE00).
+func (s *ConversionService) Convert(ctx context.Context, userID uint, from, number, to string, limit int) (*ConversionResult, error) {
+	if limit <= 0 {
+		limit = defaultConversionCandidates
+	}
+
+	fromBrand, err := s.catalogRepo.GetBrandByCode(ctx, NormalizeBrandCode(from))
+	if err != nil {
+		return nil, err
+	}
+	toBrand, err := s.catalogRepo.GetBrandByCode(ctx, NormalizeBrandCode(to))
+	if err != nil {
+		return nil, err
+	}
+	source, err := s.catalogRepo.GetColor(ctx, fromBrand.ID, NormalizeColorNumber(number))
+	if err != nil {
+		return nil, err
+	}
+	sourceLab := RGBToLab(source.R, source.G, source.B)
+
+	var candidates []ConversionCandidate
+	official, err := s.catalogRepo.GetConversions(ctx, source.ID, toBrand.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range official {
+		candidates = append(candidates, ConversionCandidate{
+			Color:    c,
+			Official: true,
+			DeltaE:   CIEDE2000(sourceLab, RGBToLab(c.R, c.G, c.B)),
+		})
+	}
+
+	if len(candidates) == 0 {
+		colors, err := s.catalogRepo.GetColors(ctx, toBrand.ID, "", false)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range colors {
+			candidates = append(candidates, ConversionCandidate{
+				Color:  c,
+				DeltaE: CIEDE2000(sourceLab, RGBToLab(c.R, c.G, c.B)),
+			})
+		}
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].DeltaE < candidates[j].DeltaE })
+		if len(candidates) > limit {
+			candidates = candidates[:limit]
+		}
+	}
+
+	if err := s.markOwned(ctx, userID, toBrand, candidates); err != nil {
+		return nil, err
+	}
+
+	source.Brand = fromBrand
+	if candidates == nil {
+		candidates = []ConversionCandidate{}
+	}
+	return &ConversionResult{From: *source, To: *toBrand, Candidates: candidates}, nil
+}
+
+func (s *ConversionService) markOwned(ctx context.Context, userID uint, brand *Brand, candidates []ConversionCandidate) error {
+	if len(candidates) == 0 {
+		return nil
+	}
+	keys := make([]ThreadKey, len(candidates))
+	for i, c := range candidates {
+		keys[i] = ThreadKey{Brand: brand.Name, ThreadId: c.Color.Number}
+	}
+	threads, err := s.threadRepo.GetByKeys(ctx, userID, keys)
+	if err != nil {
+		return err
+	}
+
+	owned := make(map[string]int64, len(threads))
+	for _, t := range threads {
+		owned[t.ThreadId] = t.ThreadCount
+	}
+	for i := range candidates {
+		if count, ok := owned[candidates[i].Color.Number]; ok {
+			candidates[i].Owned = true
+			candidates[i].ThreadCount = count
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/csv"
+	"html/template"
+	"io"
+	"strconv"
+	"time"
+)
+
+var shoppingListCSVColumns = []string{"brand", "thread_id", "quantity", "source", "note", "bought_at"}
+
+func writeShoppingListCSV(w io.Writer, items []ShoppingListItem) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(shoppingListCSVColumns); err != nil {
+		return err
+	}
+	for _, item := range items {
+		boughtAt := ""
+		if item.BoughtAt != nil {
+			boughtAt = item.BoughtAt.Format(time.RFC3339)
+		}
+		record := []string{
+			item.Brand,
+			item.ThreadId,
+			strconv.FormatInt(item.Quantity, 10),
+			item.Source,
+			item.Note,
+			boughtAt,
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// shoppingListHTML est une page imprimable, avec une case à cocher par article.
+var shoppingListHTML = template.Must(template.New("shopping-list").Parse(`<!DOCTYPE html>
+<html lang="fr">
+<head>
+<meta charset="utf-8">
+<title>Liste de courses</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ccc; padding: 6px 8px; text-align: left; }
+td.box { width: 1.5em; }
+td.box span { display: inline-block; width: 1em; height: 1em; border: 1px solid #333; }
+.bought { color: #888; text-decoration: line-through; }
+@media print { body { margin: 0; } }
+</style>
+</head>
+<body>
+<h1>Liste de courses</h1>
+<p>{{.Date}} — {{len .Items}} article(s)</p>
+<table>
+<thead><tr><th></th><th>Marque</th><th>Coloris</th><th>Quantité</th><th>Note</th></tr></thead>
+<tbody>
+{{range .Items}}<tr{{if .BoughtAt}} class="bought"{{end}}><td class="box"><span></span></td><td>{{.Brand}}</td><td>{{.ThreadId}}</td><td>{{.Quantity}}</td><td>{{.Note}}</td></tr>
+{{end}}</tbody>
+</table>
+</body>
+</html>
+`))
+
+func writeShoppingListHTML(w io.Writer, items []ShoppingListItem) error {
+	return shoppingListHTML.Execute(w, struct {
+		Date  string
+		Items []ShoppingListItem
+	}{
+		Date:  time.Now().Format("02/01/2006"),
+		Items: items,
+	})
+}
@@ -0,0 +1,134 @@
+// Package skein estime la longueur de fil et le nombre d'échevettes
+// nécessaires à une broderie au point de croix.
+//
+// Les longueurs sont calculées en brin simple : un point brodé à deux brins
+// consomme deux fois la longueur d'un point à un brin. Une échevette de
+// coton mouliné contient Length mètres de Strands brins séparables.
+package skein
+
+import (
+	"errors"
+	"math"
+)
+
+const (
+	// inch est la longueur d'un pouce en mètres : le compte d'une toile est
+	// un nombre de points par pouce.
+	inch = 0.0254
+	// KnotLength est la longueur de brin consommée par un point de nœud.
+	KnotLength = 0.03
+	// DefaultWasteFactor couvre les départs, arrêts et passages entre points.
+	DefaultWasteFactor = 0.2
+)
+
+var (
+	ErrInvalidFabric  = errors.New("fabric count must be positive")
+	ErrInvalidStrands = errors.New("strands must be positive")
+	ErrInvalidWaste   = errors.New("waste factor cannot be negative")
+	ErrInvalidSkein   = errors.New("skein length and strands must be positive")
+)
+
+// Fabric décrit la toile : 14 pour une Aida 14, 28 et OverTwo pour un lin
+// 28 fils brodé sur deux fils.
+type Fabric struct {
+	Count   float64
+	OverTwo bool
+}
+
+// StitchesPerInch renvoie le nombre de points réellement brodés par pouce.
+func (f Fabric) StitchesPerInch() float64 {
+	if f.OverTwo {
+		return f.Count / 2
+	}
+	return f.Count
+}
+
+// StitchSize renvoie le côté d'une case de la grille, en mètres.
+func (f Fabric) StitchSize() float64 {
+	return inch / f.StitchesPerInch()
+}
+
+// Params regroupe la toile, le nombre de brins et la marge de déchet
+// (0.2 pour 20 %).
+type Params struct {
+	Fabric      Fabric
+	Strands     int
+	WasteFactor float64
+}
+
+func (p Params) validate() error {
+	if p.Fabric.Count <= 0 {
+		return ErrInvalidFabric
+	}
+	if p.Strands <= 0 {
+		return ErrInvalidStrands
+	}
+	if p.WasteFactor < 0 {
+		return ErrInvalidWaste
+	}
+	return nil
+}
+
+// Usage compte les points d'un coloris. Half regroupe demi-points et quarts
+// de point ; Backstitch est une longueur exprimée en cases de la grille.
+type Usage struct {
+	Full       float64
+	Half       float64
+	Backstitch float64
+	Knots      float64
+}
+
+// Longueurs de brin simple par case, en multiples du côté de la case : une
+// croix passe deux diagonales sur l'endroit et deux côtés sur l'envers.
+var (
+	fullStitchFactor = 2*math.Sqrt2 + 2
+	halfStitchFactor = math.Sqrt2 + 1
+	// Un point arrière passe une longueur sur l'endroit et deux sur l'envers
+	backstitchFactor = 3.0
+)
+
+// Length renvoie la longueur totale de brin simple nécessaire, déchet
+// compris, en mètres.
+func Length(u Usage, p Params) (float64, error) {
+	if err := p.validate(); err != nil {
+		return 0, err
+	}
+	size := p.Fabric.StitchSize()
+	perStrand := u.Full*fullStitchFactor*size +
+		u.Half*halfStitchFactor*size +
+		u.Backstitch*backstitchFactor*size +
+		u.Knots*KnotLength
+	return perStrand * float64(p.Strands) * (1 + p.WasteFactor), nil
+}
+
+// Skein décrit une échevette : longueur en mètres et nombre de brins.
+type Skein struct {
+	Length  float64
+	Strands int
+}
+
+// Skeins convertit une longueur de brin simple en nombre d'échevettes.
+func Skeins(length float64, s Skein) (float64, error) {
+	if s.Length <= 0 || s.Strands <= 0 {
+		return 0, ErrInvalidSkein
+	}
+	return length / (s.Length * float64(s.Strands)), nil
+}
+
+// ToBuy arrondit un nombre d'échevettes à l'unité supérieure, en tolérant
+// les erreurs d'arrondi des calculs flottants.
+func ToBuy(skeins float64) int64 {
+	if skeins <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(skeins - 1e-9))
+}
+
+// CoveredStitches estime le nombre de points d'une grille de width x height
+// cases remplie à coverage (entre 0 et 1).
+func CoveredStitches(width, height int, coverage float64) float64 {
+	if width <= 0 || height <= 0 || coverage <= 0 {
+		return 0
+	}
+	return float64(width) * float64(height) * math.Min(coverage, 1)
+}
@@ -0,0 +1,166 @@
+package skein
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// almostEqual compare à 1e-6 près en relatif : les valeurs attendues sont
+// calculées à la main avec une dizaine de chiffres significatifs.
+func almostEqual(got, want float64) bool {
+	return math.Abs(got-want) <= 1e-6*math.Max(1, math.Abs(want))
+}
+
+func TestFabric(t *testing.T) {
+	tests := []struct {
+		name          string
+		fabric        Fabric
+		perInch, size float64
+	}{
+		{"aida 14", Fabric{Count: 14}, 14, 0.0254 / 14},
+		{"aida 18", Fabric{Count: 18}, 18, 0.0254 / 18},
+		{"linen 28 over two", Fabric{Count: 28, OverTwo: true}, 14, 0.0254 / 14},
+		{"linen 32 over one", Fabric{Count: 32}, 32, 0.00079375},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.fabric.StitchesPerInch(); !almostEqual(got, tt.perInch) {
+				t.Errorf("StitchesPerInch() = %v, want %v", got, tt.perInch)
+			}
+			if got := tt.fabric.StitchSize(); !almostEqual(got, tt.size) {
+				t.Errorf("StitchSize() = %v, want %v", got, tt.size)
+			}
+		})
+	}
+}
+
+func TestLength(t *testing.T) {
+	// Une case d'Aida 14 mesure 0,0254 / 14 = 0,00181428571 m ; une croix
+	// consomme (2√2 + 2) = 4,82842712 cases de brin, un demi-point
+	// (√2 + 1) = 2,41421356 cases et un point arrière 3 cases.
+	aida14 := Fabric{Count: 14}
+	tests := []struct {
+		name   string
+		usage  Usage
+		params Params
+		want   float64
+	}{
+		{"aida 14 full, 2 strands", Usage{Full: 1000}, Params{Fabric: aida14, Strands: 2}, 17.5202925},
+		{"linen 28 over two matches aida 14", Usage{Full: 1000}, Params{Fabric: Fabric{Count: 28, OverTwo: true}, Strands: 2}, 17.5202925},
+		{"aida 18 full, 2 strands", Usage{Full: 1000}, Params{Fabric: Fabric{Count: 18}, Strands: 2}, 13.6268942},
+		{"half stitches, 1 strand", Usage{Half: 1000}, Params{Fabric: aida14, Strands: 1}, 4.3800731},
+		{"backstitch length, 1 strand", Usage{Backstitch: 100}, Params{Fabric: aida14, Strands: 1}, 0.5442857},
+		{"knots do not depend on the fabric", Usage{Knots: 10}, Params{Fabric: aida14, Strands: 1}, 0.3},
+		{"waste factor", Usage{Full: 1000}, Params{Fabric: aida14, Strands: 2, WasteFactor: 0.2}, 21.0243510},
+		{"default waste factor, mixed usage", Usage{Full: 500, Half: 200, Backstitch: 50}, Params{Fabric: aida14, Strands: 2, WasteFactor: DefaultWasteFactor}, 13.2677536},
+		{"no stitches", Usage{}, Params{Fabric: aida14, Strands: 2}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Length(tt.usage, tt.params)
+			if err != nil {
+				t.Fatalf("Length() error = %v", err)
+			}
+			if !almostEqual(got, tt.want) {
+				t.Errorf("Length() = %.7f, want %.7f", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLengthInvalidParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		params Params
+		want   error
+	}{
+		{"no fabric count", Params{Strands: 2}, ErrInvalidFabric},
+		{"negative fabric count", Params{Fabric: Fabric{Count: -14}, Strands: 2}, ErrInvalidFabric},
+		{"no strands", Params{Fabric: Fabric{Count: 14}}, ErrInvalidStrands},
+		{"negative waste", Params{Fabric: Fabric{Count: 14}, Strands: 2, WasteFactor: -0.1}, ErrInvalidWaste},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Length(Usage{Full: 1}, tt.params); !errors.Is(err, tt.want) {
+				t.Errorf("Length() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSkeins(t *testing.T) {
+	// Une échevette DMC : 8 m de 6 brins, soit 48 m de brin simple
+	dmc := Skein{Length: 8, Strands: 6}
+	tests := []struct {
+		name   string
+		length float64
+		skein  Skein
+		want   float64
+		err    error
+	}{
+		{"half a skein", 24, dmc, 0.5, nil},
+		{"exactly one skein", 48, dmc, 1, nil},
+		{"aida 14 full with waste", 21.0243510, dmc, 0.4380073, nil},
+		{"two skeins", 96, dmc, 2, nil},
+		{"no length", 10, Skein{Strands: 6}, 0, ErrInvalidSkein},
+		{"no strands", 10, Skein{Length: 8}, 0, ErrInvalidSkein},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Skeins(tt.length, tt.skein)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Skeins() error = %v, want %v", err, tt.err)
+			}
+			if !almostEqual(got, tt.want) {
+				t.Errorf("Skeins() = %.7f, want %.7f", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToBuy(t *testing.T) {
+	tests := []struct {
+		skeins float64
+		want   int64
+	}{
+		{-1, 0},
+		{0, 0},
+		{0.01, 1},
+		{0.5, 1},
+		{1, 1},
+		// 0,1 + 0,2 + 0,7 ne tombe pas exactement sur 1 en flottant
+		{0.1 + 0.2 + 0.7, 1},
+		{1 + 1e-12, 1},
+		{1.01, 2},
+		{3, 3},
+	}
+	for _, tt := range tests {
+		if got := ToBuy(tt.skeins); got != tt.want {
+			t.Errorf("ToBuy(%v) = %d, want %d", tt.skeins, got, tt.want)
+		}
+	}
+}
+
+func TestCoveredStitches(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		coverage      float64
+		want          float64
+	}{
+		{"full grid", 100, 50, 1, 5000},
+		{"half covered", 10, 20, 0.5, 100},
+		{"coverage above one is capped", 10, 10, 1.5, 100},
+		{"no coverage", 10, 10, 0, 0},
+		{"empty grid", 0, 10, 1, 0},
+		{"negative size", 10, -1, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CoveredStitches(tt.width, tt.height, tt.coverage); !almostEqual(got, tt.want) {
+				t.Errorf("CoveredStitches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
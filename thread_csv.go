@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// threadCSVColumns reprend les champs de ThreadDto, dans l'ordre d'export.
+var threadCSVColumns = []string{"thread_id", "brand", "thread_count", "skeins", "cards", "spools", "partial_metres", "is_custom", "min_quantity", "location_id"}
+
+// threadCSVLegacyColumns sont encore acceptées à l'import, avec thread_count,
+// pour les fichiers exportés avant les quantités par format.
+var threadCSVLegacyColumns = []string{"is_e", "is_c", "is_s"}
+
+// ThreadCSVMapping associe un champ de ThreadDto au nom de colonne du fichier.
+type ThreadCSVMapping map[string]string
+
+// threadCSVReader lit un inventaire CSV ligne par ligne selon un mapping.
+type threadCSVReader struct {
+	reader  *csv.Reader
+	indexes map[string]int
+	line    int
+}
+
+func newThreadCSVReader(r io.Reader, mapping ThreadCSVMapping) (*threadCSVReader, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.New("missing CSV header")
+	}
+	positions := make(map[string]int, len(header))
+	for i, name := range header {
+		positions[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+
+	for field := range mapping {
+		if !isThreadCSVColumn(field) {
+			return nil, fmt.Errorf("unknown field %s in mapping", field)
+		}
+	}
+
+	// Sans mapping explicite, une colonne porte le nom du champ
+	indexes := map[string]int{}
+	for _, field := range append(threadCSVColumns, threadCSVLegacyColumns...) {
+		column := field
+		if mapped, ok := mapping[field]; ok {
+			column = mapped
+		}
+		i, ok := positions[strings.ToLower(strings.TrimSpace(column))]
+		if !ok {
+			if _, explicit := mapping[field]; explicit {
+				return nil, fmt.Errorf("column %q not found", column)
+			}
+			continue
+		}
+		indexes[field] = i
+	}
+	if _, ok := indexes["thread_id"]; !ok {
+		return nil, errors.New("no column mapped to thread_id")
+	}
+
+	return &threadCSVReader{reader: reader, indexes: indexes, line: 1}, nil
+}
+
+// Provides indique si le fichier contient une colonne pour le champ : les
+// champs absents gardent leur valeur lors de la mise à jour d'un fil existant.
+func (r *threadCSVReader) Provides(field string) bool {
+	_, ok := r.indexes[field]
+	return ok
+}
+
+func isThreadCSVColumn(field string) bool {
+	for _, c := range append(threadCSVColumns, threadCSVLegacyColumns...) {
+		if c == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Next renvoie la ligne suivante (numéro de ligne du fichier) ou io.EOF.
+// Une erreur de format n'interrompt pas la lecture des lignes suivantes.
+func (r *threadCSVReader) Next() (int, ThreadDto, error) {
+	record, err := r.reader.Read()
+	r.line++
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return r.line, ThreadDto{}, io.EOF
+		}
+		return r.line, ThreadDto{}, err
+	}
+
+	value := func(field string) string {
+		i, ok := r.indexes[field]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	dto := ThreadDto{
+		ThreadId: value("thread_id"),
+		Brand:    value("brand"),
+	}
+	if dto.ThreadId == "" {
+		return r.line, dto, errors.New("thread_id is empty")
+	}
+	if v := value("thread_count"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return r.line, dto, fmt.Errorf("invalid thread_count %q", v)
+		}
+		dto.ThreadCount = n
+	}
+	if v := value("min_quantity"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return r.line, dto, fmt.Errorf("invalid min_quantity %q", v)
+		}
+		dto.MinQuantity = &n
+	}
+	if v := value("location_id"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return r.line, dto, fmt.Errorf("invalid location_id %q", v)
+		}
+		id := uint(n)
+		dto.LocationID = &id
+	}
+	for field, dest := range map[string]**int64{"skeins": &dto.Skeins, "cards": &dto.Cards, "spools": &dto.Spools} {
+		if v := value(field); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return r.line, dto, fmt.Errorf("invalid %s %q", field, v)
+			}
+			*dest = &n
+		}
+	}
+	if v := value("partial_metres"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return r.line, dto, fmt.Errorf("invalid partial_metres %q", v)
+		}
+		dto.PartialMetres = &f
+	}
+	for field, dest := range map[string]*bool{"is_e": &dto.IsE, "is_c": &dto.IsC, "is_s": &dto.IsS, "is_custom": &dto.IsCustom} {
+		if v := value(field); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return r.line, dto, fmt.Errorf("invalid %s %q", field, v)
+			}
+			*dest = b
+		}
+	}
+	return r.line, dto, nil
+}
+
+func threadCSVRecord(t Thread) []string {
+	minQuantity, locationID := "", ""
+	if t.MinQuantity != nil {
+		minQuantity = strconv.FormatInt(*t.MinQuantity, 10)
+	}
+	if t.LocationID != nil {
+		locationID = strconv.FormatUint(uint64(*t.LocationID), 10)
+	}
+	return []string{
+		t.ThreadId,
+		t.Brand,
+		strconv.FormatInt(t.ThreadCount, 10),
+		strconv.FormatInt(t.Skeins, 10),
+		strconv.FormatInt(t.Cards, 10),
+		strconv.FormatInt(t.Spools, 10),
+		strconv.FormatFloat(t.PartialMetres, 'f', -1, 64),
+		strconv.FormatBool(t.IsCustom),
+		minQuantity,
+		locationID,
+	}
+}